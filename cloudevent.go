@@ -0,0 +1,224 @@
+package daprsvc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const cloudEventContentType = "application/cloudevents+json"
+
+// CloudEvents 1.0 event in structured content mode. Optional attributes are left out when empty.
+type CloudEvent struct {
+	SpecVersion     string
+	Id              string
+	Source          string
+	Type            string
+	DataContentType string
+	DataSchema      string
+	Subject         string
+	Time            time.Time       // NOTE: Zero when absent or not a valid RFC3339 timestamp.
+	Data            json.RawMessage // NOTE: Json value of the data attribute, nil when absent.
+	DataBase64      []byte          // NOTE: Decoded data_base64 attribute, nil when absent.
+	Extensions      map[string]any  // NOTE: All attributes not defined by the CloudEvents spec, e.g. traceparent.
+}
+
+var cloudEventStringAttributes = []string{"specversion", "id", "source", "type", "datacontenttype", "dataschema", "subject", "time", "data_base64"}
+
+func (ce *CloudEvent) UnmarshalJSON(envelope []byte) error {
+	attributes := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(envelope, &attributes); err != nil {
+		return err
+	}
+
+	stringAttributes := make(map[string]string, len(cloudEventStringAttributes))
+	for _, name := range cloudEventStringAttributes {
+		raw, present := attributes[name]
+		delete(attributes, name)
+		if !present || string(raw) == "null" {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("Cloud-event attribute '%s' is not a string.", name)
+		}
+		stringAttributes[name] = value
+	}
+
+	*ce = CloudEvent{
+		SpecVersion:     stringAttributes["specversion"],
+		Id:              stringAttributes["id"],
+		Source:          stringAttributes["source"],
+		Type:            stringAttributes["type"],
+		DataContentType: stringAttributes["datacontenttype"],
+		DataSchema:      stringAttributes["dataschema"],
+		Subject:         stringAttributes["subject"],
+	}
+
+	if t, present := stringAttributes["time"]; present {
+		if timestamp, err := time.Parse(time.RFC3339, t); err == nil {
+			ce.Time = timestamp
+		}
+	}
+
+	if dataBase64, present := stringAttributes["data_base64"]; present {
+		data, err := base64.StdEncoding.DecodeString(dataBase64)
+		if err != nil {
+			return fmt.Errorf("Failed to decode cloud-event base64 data.")
+		}
+		ce.DataBase64 = data
+	}
+
+	if data, present := attributes["data"]; present {
+		ce.Data = append(json.RawMessage(nil), data...)
+		delete(attributes, "data")
+	}
+
+	for name, raw := range attributes {
+		var value any
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("Failed to decode cloud-event extension attribute '%s': %w", name, err)
+		}
+		if ce.Extensions == nil {
+			ce.Extensions = make(map[string]any, len(attributes))
+		}
+		ce.Extensions[name] = value
+	}
+
+	return nil
+}
+
+func (ce CloudEvent) MarshalJSON() ([]byte, error) {
+	attributes := make(map[string]any, len(ce.Extensions)+10)
+	for name, value := range ce.Extensions {
+		attributes[name] = value
+	}
+
+	optionalStrings := map[string]string{
+		"specversion":     ce.SpecVersion,
+		"id":              ce.Id,
+		"source":          ce.Source,
+		"type":            ce.Type,
+		"datacontenttype": ce.DataContentType,
+		"dataschema":      ce.DataSchema,
+		"subject":         ce.Subject,
+	}
+	for name, value := range optionalStrings {
+		if value != "" {
+			attributes[name] = value
+		}
+	}
+	if attributes["specversion"] == nil {
+		attributes["specversion"] = "1.0"
+	}
+	if !ce.Time.IsZero() {
+		attributes["time"] = ce.Time.Format(time.RFC3339Nano)
+	}
+	if ce.Data != nil {
+		attributes["data"] = ce.Data
+	}
+	if ce.DataBase64 != nil {
+		attributes["data_base64"] = base64.StdEncoding.EncodeToString(ce.DataBase64)
+	}
+
+	return json.Marshal(attributes)
+}
+
+// Extracts the data of the event as bytes:
+//   - data_base64 is decoded, whatever the content-type.
+//   - data with a json content-type (or none) is the json value itself.
+//   - data with any other content-type is the contents of a json string, as the dapr daemon delivers e.g. text and
+//     xml, and the json value itself otherwise.
+//   - no data (or null) results in empty data.
+func (ce CloudEvent) data() ([]byte, error) {
+	hasData := ce.Data != nil && string(bytes.TrimSpace(ce.Data)) != "null"
+	switch {
+	case ce.DataBase64 != nil && hasData:
+		return nil, fmt.Errorf("Cloud-event has both data and data_base64.")
+	case ce.DataBase64 != nil:
+		return ce.DataBase64, nil
+	case !hasData:
+		return []byte{}, nil
+	case (Message{ContentType: ce.DataContentType}).ContainsJsonData():
+		return ce.Data, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(ce.Data, &text); err == nil {
+		return []byte(text), nil
+	}
+	return ce.Data, nil
+}
+
+// Returns the extension attribute as string, or an empty string when it is absent or not a string.
+func (ce CloudEvent) extensionString(name string) string {
+	value, _ := ce.Extensions[name].(string)
+	return value
+}
+
+const binaryCloudEventHeaderPrefix = "Ce-"
+
+// Binary content mode cloud-events carry their attributes in ce-* headers, at least the spec version.
+func isBinaryCloudEvent(header http.Header) bool {
+	return header.Get(binaryCloudEventHeaderPrefix+"Specversion") != ""
+}
+
+// Constructs a cloud-event from a binary content mode request, where the attributes come from the ce-* headers
+// and the content-type header, and the body is the data.
+func cloudEventFromBinary(header http.Header, body []byte) CloudEvent {
+	attributes := make(map[string]string)
+	for key, values := range header {
+		if !strings.HasPrefix(key, binaryCloudEventHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(key, binaryCloudEventHeaderPrefix))
+		// NOTE: Header values are percent-encoded where needed.
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		attributes[name] = value
+	}
+
+	ce := CloudEvent{DataContentType: header.Get("Content-Type")}
+	for name, value := range attributes {
+		switch name {
+		case "specversion":
+			ce.SpecVersion = value
+		case "id":
+			ce.Id = value
+		case "source":
+			ce.Source = value
+		case "type":
+			ce.Type = value
+		case "dataschema":
+			ce.DataSchema = value
+		case "subject":
+			ce.Subject = value
+		case "time":
+			if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+				ce.Time = timestamp
+			}
+		default:
+			if ce.Extensions == nil {
+				ce.Extensions = make(map[string]any)
+			}
+			ce.Extensions[name] = value
+		}
+	}
+
+	if (Message{ContentType: ce.DataContentType}).ContainsJsonData() {
+		ce.Data = body
+	} else {
+		ce.DataBase64 = body
+	}
+
+	return ce
+}
@@ -0,0 +1,73 @@
+// Renders the subscriptions of a running daprsvc application (or any Dapr application) as declarative
+// Subscription resources, by reading its /dapr/subscribe response.
+//
+// Usage:
+//
+//	daprsvc-manifests -url http://localhost:3000/dapr/subscribe -scopes order-service > subscriptions.yaml
+//	curl -s localhost:3000/dapr/subscribe | daprsvc-manifests -file - -name-prefix order-service
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	daprsvc "github.com/tbknl/go-sdk-daprsvc"
+)
+
+func readSubscribeResponse(url string, file string) ([]byte, error) {
+	switch {
+	case file == "-":
+		return io.ReadAll(os.Stdin)
+	case file != "":
+		return os.ReadFile(file)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request to '%s' returned status %d.", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:3000/dapr/subscribe", "Url of the /dapr/subscribe endpoint of the application.")
+	file := flag.String("file", "", "Read the /dapr/subscribe response from a file instead, or from stdin for '-'.")
+	namePrefix := flag.String("name-prefix", "", "Prefix for the resource names, e.g. the app id.")
+	namespace := flag.String("namespace", "", "Namespace of the resources.")
+	scopes := flag.String("scopes", "", "Comma separated app ids allowed to use the subscriptions.")
+	flag.Parse()
+
+	data, err := readSubscribeResponse(*url, *file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read subscriptions: %s\n", err)
+		os.Exit(1)
+	}
+
+	var subscriptions []daprsvc.Subscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse subscriptions: %s\n", err)
+		os.Exit(1)
+	}
+
+	options := daprsvc.ManifestOptions{
+		NamePrefix: *namePrefix,
+		Namespace:  *namespace,
+	}
+	if *scopes != "" {
+		options.Scopes = strings.Split(*scopes, ",")
+	}
+
+	if err := daprsvc.WriteSubscriptionManifests(os.Stdout, subscriptions, options); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write manifests: %s\n", err)
+		os.Exit(1)
+	}
+}
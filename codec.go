@@ -0,0 +1,126 @@
+package daprsvc
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Decodes message data of a content-type into a Go value.
+type Codec interface {
+	Decode(data []byte, v any) error
+}
+
+type CodecFunc func(data []byte, v any) error
+
+func (fn CodecFunc) Decode(data []byte, v any) error {
+	return fn(data, v)
+}
+
+type UnknownContentTypeError struct {
+	ContentType string
+}
+
+func (err *UnknownContentTypeError) Error() string {
+	return fmt.Sprintf("No codec registered for content-type '%s'.", err.ContentType)
+}
+
+// Decodes data into a string or byte slice, for content-types that have no further structure.
+var textCodec = CodecFunc(func(data []byte, v any) error {
+	switch target := v.(type) {
+	case *string:
+		*target = string(data)
+	case *[]byte:
+		*target = data
+	default:
+		return fmt.Errorf("Can't decode text data into %T.", v)
+	}
+	return nil
+})
+
+// Codecs available to every service, which the codecs registered on a service override.
+var builtinCodecs = map[string]Codec{
+	"application/json":         CodecFunc(json.Unmarshal),
+	"+json":                    CodecFunc(json.Unmarshal),
+	"application/xml":          CodecFunc(xml.Unmarshal),
+	"text/xml":                 CodecFunc(xml.Unmarshal),
+	"+xml":                     CodecFunc(xml.Unmarshal),
+	"text/*":                   textCodec,
+	"application/octet-stream": textCodec,
+}
+
+type codecRegistry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+func (cr *codecRegistry) register(mediaType string, codec Codec) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.codecs == nil {
+		cr.codecs = make(map[string]Codec)
+	}
+	cr.codecs[strings.ToLower(mediaType)] = codec
+}
+
+// Returns the registered codec for the key, or else the builtin one. Safe to call on a nil registry.
+func (cr *codecRegistry) get(key string) (Codec, bool) {
+	if cr != nil {
+		cr.mu.RLock()
+		codec, found := cr.codecs[key]
+		cr.mu.RUnlock()
+		if found {
+			return codec, true
+		}
+	}
+	codec, found := builtinCodecs[key]
+	return codec, found
+}
+
+// Looks up the codec for the content-type, from the most specific key (the media type) to the least specific one
+// (all subtypes of the type).
+func (cr *codecRegistry) lookup(contentType string) (Codec, error) {
+	mediaType := mediaTypeOf(contentType)
+	if mediaType == "" {
+		mediaType = "application/json" // NOTE: Cloud-event data without content-type is json.
+	}
+
+	keys := []string{mediaType}
+	if idx := strings.LastIndex(mediaType, "+"); idx >= 0 {
+		keys = append(keys, mediaType[idx:])
+	}
+	if mainType, _, found := strings.Cut(mediaType, "/"); found {
+		keys = append(keys, mainType+"/*")
+	}
+	for _, key := range keys {
+		if codec, found := cr.get(key); found {
+			return codec, nil
+		}
+	}
+	return nil, &UnknownContentTypeError{ContentType: contentType}
+}
+
+// Registers the codec for a media type on the service, overriding the builtin codec for it. Besides exact media types
+// ("application/x-protobuf"), codecs can be registered for all subtypes of a type ("text/*") and for structured
+// syntax suffixes ("+json"). The most specific codec is used for decoding.
+func (ev *events) RegisterCodec(mediaType string, codec Codec) {
+	ev.codecs.register(mediaType, codec)
+}
+
+// Decodes the message data into v, using the codec for the content-type of the message: the one registered on the
+// service that received the message, or else the builtin one. Decoding into a *[]byte always results in the raw
+// data.
+func (msg Message) Decode(v any) error {
+	if target, ok := v.(*[]byte); ok {
+		*target = msg.Data
+		return nil
+	}
+
+	codec, err := msg.codecs.lookup(msg.ContentType)
+	if err != nil {
+		return err
+	}
+	return codec.Decode(msg.Data, v)
+}
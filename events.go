@@ -7,9 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,38 +23,66 @@ import (
 )
 
 type PubsubOptions struct {
-	RawPayload   bool // NOTE: If true, instruct dapr daemon to always wrap message in a cloud-event.
-	NoCloudEvent bool // NOTE: if true, do not parse incoming message data before sending to handler.
-	// TODO: Support for matcing rules and priorities.
+	RawPayload   bool   // NOTE: If true, instruct dapr daemon to always wrap message in a cloud-event.
+	NoCloudEvent bool   // NOTE: if true, do not parse incoming message data before sending to handler.
+	Match        string // NOTE: CEL expression; if set, the handler only receives messages on the topic matching it.
+	Priority     int    // NOTE: Match rules on the same topic are evaluated in ascending priority order.
+	// NOTE: If set, the dapr daemon forwards messages that are dropped or run out of retries to this topic.
+	DeadLetterTopic string
+	// NOTE: Subscription metadata for the pubsub component, like a consumer id. Metadata of all handlers for the
+	// topic is merged, the first value registered for a key is used. RawPayload overrides "rawPayload" when set.
+	Metadata map[string]string
+	// NOTE: Maximum size in bytes of a message request body, overriding the maximum size of the service when set.
+	// Larger messages are dropped.
+	MaxBodySize int64
+	// NOTE: URI of a registered JSON Schema the message data must match, instead of the dataschema of the
+	// cloud-event. Messages that don't match are dropped.
+	Schema string
+	// NOTE: If true, a message is dropped instead of retried when its handler panics.
+	DropOnPanic bool
+	// NOTE: Middleware applied to this handler only, inside the service and pubsub middleware.
+	Middleware []MessageMiddleware
 }
 
 type MessageFields struct {
-	Source    string
-	Type      string
-	Schema    string
-	Subject   string
-	Timestamp time.Time
+	SpecVersion string
+	Source      string
+	Type        string
+	Schema      string
+	Subject     string
+	Timestamp   time.Time
 }
 
 type Message struct {
 	PubsubName  string
 	Topic       string
 	Id          string
+	EntryId     string // NOTE: Only set for messages delivered or published in bulk.
 	Data        []byte
 	ContentType string
 	Metadata    map[string]string
 	Fields      MessageFields
+	Extensions  map[string]any // NOTE: Cloud-event extension attributes, including the ones added by the dapr daemon.
+	RawEnvelope []byte         // NOTE: The complete cloud-event, as received.
 	Trace       struct {
 		Id     string
 		Parent string
 		State  string
 	}
+
+	codecs *codecRegistry // NOTE: Codecs of the service that received the message, used by Decode.
 }
 
 var regexDataContentTypeJson = regexp.MustCompile(`^[^/]+/([^/]+\+)?json$`)
 
+// Strips the parameters, like the charset, from a content-type.
+func mediaTypeOf(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+}
+
 func (msg Message) ContainsJsonData() bool {
-	return msg.ContentType == "" || regexDataContentTypeJson.MatchString(msg.ContentType)
+	mediaType := mediaTypeOf(msg.ContentType)
+	return mediaType == "" || regexDataContentTypeJson.MatchString(mediaType)
 }
 
 func (msg Message) Json(v any) error {
@@ -117,53 +149,502 @@ func MessageResultDrop(err error) MessageResult {
 
 type MessageHandler = func(ctx context.Context, message Message) MessageResult
 
+// Error of a message handler that panicked, passed to the error hook and returned in the handler response.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("Message handler panicked: %v", err.Value)
+}
+
+// Called with the error of every message handler result other than success, including a *PanicError when the
+// handler panicked.
+type MessageErrorHook = func(ctx context.Context, msg Message, err error)
+
+// Recovers from a panic in the handler call by logging it and converting it to a retry or drop result.
+func recoverMessageHandlerPanic(logger *slog.Logger, entry pubsubEntry, result *MessageResult) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	panicErr := &PanicError{Value: recovered, Stack: debug.Stack()}
+	logger.Error("Message handler panicked",
+		slog.String("pubsub", entry.pubsubName),
+		slog.String("topic", entry.topic),
+		slog.Any("panic", panicErr.Value),
+		slog.String("stack", string(panicErr.Stack)),
+	)
+	if entry.options.DropOnPanic {
+		*result = MessageResultDrop(panicErr)
+	} else {
+		*result = MessageResultRetry(panicErr)
+	}
+}
+
+func callMessageHandler(ctx context.Context, env messageHandlerEnv, entry pubsubEntry, msg Message) (result MessageResult) {
+	defer recoverMessageHandlerPanic(env.logger, entry, &result)
+	return entry.messageHandler(ctx, msg)
+}
+
+func callBulkMessageHandler(ctx context.Context, env messageHandlerEnv, entry pubsubEntry, messages []Message) (results map[string]MessageResult) {
+	var panicResult MessageResult
+	defer func() {
+		if panicResult != nil {
+			results = make(map[string]MessageResult, len(messages))
+			for _, msg := range messages {
+				results[msg.EntryId] = panicResult
+			}
+		}
+	}()
+	defer recoverMessageHandlerPanic(env.logger, entry, &panicResult)
+	return entry.bulkMessageHandler(ctx, messages)
+}
+
+// Passes each message through the middleware of the entry and calls the bulk message handler once, with the messages
+// that reach the end of their middleware chain. The middleware of each message runs in its own goroutine, and the
+// bulk handler is called when every message has either reached the end of its chain or got a result from the
+// middleware. The bulk handler is called with the context of the delivery, not with the contexts the middleware
+// passes on.
+func callBulkMessageHandlerThroughMiddleware(ctx context.Context, env messageHandlerEnv, entry pubsubEntry, messages []Message) map[string]MessageResult {
+	type arrival struct {
+		index int
+		msg   Message
+	}
+	arrivals := make(chan arrival)
+	finished := make(chan int)
+	dispatched := make(chan struct{})
+	inBatch := make([]bool, len(messages))
+	var bulkResults map[string]MessageResult
+
+	results := make([]MessageResult, len(messages))
+	for i := range messages {
+		i := i
+		handler := applyMessageMiddleware(func(ctx context.Context, msg Message) MessageResult {
+			select {
+			case arrivals <- arrival{index: i, msg: msg}:
+				<-dispatched
+			case <-dispatched:
+			}
+			if !inBatch[i] {
+				return MessageResultRetry(fmt.Errorf("Message '%s' reached the bulk message handler after it was called.", msg.EntryId))
+			}
+			return bulkResults[msg.EntryId]
+		}, entry.bulkMiddleware)
+		go func() {
+			defer func() { finished <- i }()
+			defer recoverMessageHandlerPanic(env.logger, entry, &results[i])
+			results[i] = handler(ctx, messages[i])
+		}()
+	}
+
+	batch := make([]Message, len(messages))
+	settled := make([]bool, len(messages))
+	finishedCount := 0
+	for pending := len(messages); pending > 0; {
+		select {
+		case a := <-arrivals:
+			if !inBatch[a.index] {
+				inBatch[a.index] = true
+				batch[a.index] = a.msg
+			}
+			if !settled[a.index] {
+				settled[a.index] = true
+				pending--
+			}
+		case index := <-finished:
+			finishedCount++
+			if !settled[index] {
+				settled[index] = true
+				pending--
+			}
+		}
+	}
+
+	reached := make([]Message, 0, len(messages))
+	for i, msg := range batch {
+		if inBatch[i] {
+			reached = append(reached, msg)
+		}
+	}
+	if len(reached) > 0 {
+		bulkResults = callBulkMessageHandler(ctx, env, entry, reached)
+	}
+	close(dispatched)
+	for ; finishedCount < len(messages); finishedCount++ {
+		<-finished
+	}
+
+	resultsByEntryId := make(map[string]MessageResult, len(messages))
+	for i, msg := range messages {
+		if results[i] != nil {
+			resultsByEntryId[msg.EntryId] = results[i]
+		}
+	}
+	return resultsByEntryId
+}
+
+// Wraps a message handler with shared behavior, like logging or metrics. For bulk message handlers, the middleware
+// is applied to each message of a delivery.
+type MessageMiddleware = func(next MessageHandler) MessageHandler
+
+// Wraps the handler in the middleware, the first middleware becomes the outermost.
+func applyMessageMiddleware(handler MessageHandler, middlewareLists ...[]MessageMiddleware) MessageHandler {
+	for i := len(middlewareLists) - 1; i >= 0; i-- {
+		middleware := middlewareLists[i]
+		for j := len(middleware) - 1; j >= 0; j-- {
+			handler = middleware[j](handler)
+		}
+	}
+	return handler
+}
+
+// Handles messages delivered in bulk. The result for each message is keyed by its EntryId, messages without a
+// result are retried.
+type BulkMessageHandler = func(ctx context.Context, messages []Message) map[string]MessageResult
+
+type BulkSubscribeOptions struct {
+	MaxMessagesCount   int // NOTE: Maximum number of messages per bulk delivery; zero leaves it to the dapr daemon.
+	MaxAwaitDurationMs int // NOTE: Maximum time to wait for a bulk delivery to fill up; zero leaves it to the dapr daemon.
+}
+
 type pubsubEntry struct {
 	pubsubName     string
 	topic          string
+	index          int // Registration index within the pubsub, keeps rule routes distinct.
 	options        PubsubOptions
 	messageHandler MessageHandler
+
+	bulkOptions        *BulkSubscribeOptions
+	bulkMessageHandler BulkMessageHandler
+	bulkMiddleware     []MessageMiddleware // Message middleware, applied to each message of a bulk delivery.
+
+	// Topics of the pubsub that dead-letter to this topic. The dapr daemon keeps the topic of the source in
+	// dead-lettered messages.
+	deadLetterSources []string
+}
+
+func (entry pubsubEntry) isBulk() bool {
+	return entry.bulkMessageHandler != nil
+}
+
+func (entry pubsubEntry) acceptsTopic(topic string) bool {
+	return topic == entry.topic || slices.Contains(entry.deadLetterSources, topic)
+}
+
+func (entry pubsubEntry) isRule() bool {
+	return entry.options.Match != ""
+}
+
+// Escapes a name into a single path segment, which httprouter matches literally. Bytes other than letters, digits,
+// '-', '_' and '.' are written as '~' followed by two hex digits. Since '~' itself is escaped, distinct names
+// always result in distinct segments.
+func escapeRouteSegment(name string) string {
+	allDots := strings.Trim(name, ".") == ""
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			sb.WriteByte(c)
+		case c == '.' && !allDots: // NOTE: Segments of only dots would be resolved as relative paths.
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "~%02X", c)
+		}
+	}
+	return sb.String()
 }
 
 func (entry pubsubEntry) constructRoute() string {
-	return fmt.Sprintf("/%s/%s", entry.pubsubName, entry.topic) // TODO: Add optional matching rule and priority to route somehow.
+	pubsubSegment, topicSegment := escapeRouteSegment(entry.pubsubName), escapeRouteSegment(entry.topic)
+	if entry.isRule() {
+		return fmt.Sprintf("/%s/%s/rule/%d", pubsubSegment, topicSegment, entry.index)
+	}
+	return fmt.Sprintf("/%s/%s", pubsubSegment, topicSegment)
+}
+
+// Subscription to a single topic, possibly served by several handlers through match rules.
+type topicSubscription struct {
+	pubsubName   string
+	topic        string
+	entries      []pubsubEntry // NOTE: All entries for the topic, in registration order.
+	defaultEntry *pubsubEntry
+	rules        []pubsubEntry
+}
+
+func (sub topicSubscription) hasRules() bool {
+	return len(sub.rules) > 0
+}
+
+// RawPayload applies to the whole subscription, so it is enabled when any handler for the topic requests it.
+func (sub topicSubscription) rawPayload() bool {
+	for _, entry := range sub.entries {
+		if entry.options.RawPayload {
+			return true
+		}
+	}
+	return false
+}
+
+// The dead-letter topic applies to the whole subscription, the first one configured for the topic is used.
+func (sub topicSubscription) deadLetterTopic() string {
+	for _, entry := range sub.entries {
+		if entry.options.DeadLetterTopic != "" {
+			return entry.options.DeadLetterTopic
+		}
+	}
+	return ""
+}
+
+// Bulk delivery applies to the whole subscription, the first bulk options registered for the topic are used.
+func (sub topicSubscription) bulkSubscribe() *BulkSubscribeOptions {
+	for _, entry := range sub.entries {
+		if entry.bulkOptions != nil {
+			return entry.bulkOptions
+		}
+	}
+	return nil
+}
+
+// Merges the metadata of all handlers for the topic, the first value registered for a key is used. RawPayload
+// takes precedence over a "rawPayload" metadata value when enabled.
+func (sub topicSubscription) metadata() map[string]string {
+	result := make(map[string]string)
+	for _, entry := range sub.entries {
+		for key, value := range entry.options.Metadata {
+			if _, exists := result[key]; !exists {
+				result[key] = value
+			}
+		}
+	}
+	if sub.rawPayload() {
+		result["rawPayload"] = "true"
+	}
+	return result
 }
 
 type pubsub struct {
-	name    string
-	entries []pubsubEntry
+	name       string
+	entries    []pubsubEntry
+	middleware []MessageMiddleware
+}
+
+// Adds middleware for all message handlers of the pubsub, inside the service middleware. For bulk message handlers
+// the middleware is applied to each message.
+func (ps *pubsub) UseMessageMiddleware(middleware ...MessageMiddleware) {
+	ps.middleware = append(ps.middleware, middleware...)
 }
 
 func (ps *pubsub) RegisterMessageHandler(topic string, options PubsubOptions, handler MessageHandler) {
 	ps.entries = append(ps.entries, pubsubEntry{
 		pubsubName:     ps.name,
 		topic:          topic,
+		index:          len(ps.entries),
 		options:        options,
 		messageHandler: handler,
 	})
 }
 
-type pubsubMap map[string]*pubsub
+// Registers a handler that receives the messages on the topic in bulk, instead of one request per message.
+func (ps *pubsub) RegisterBulkMessageHandler(topic string, options PubsubOptions, bulkOptions BulkSubscribeOptions, handler BulkMessageHandler) {
+	ps.entries = append(ps.entries, pubsubEntry{
+		pubsubName:         ps.name,
+		topic:              topic,
+		index:              len(ps.entries),
+		options:            options,
+		bulkOptions:        &bulkOptions,
+		bulkMessageHandler: handler,
+	})
+}
+
+// Registers a handler that receives the message data decoded into a value of type T. Messages that can't be
+// decoded are dropped.
+func RegisterTypedHandler[T any](ps *pubsub, topic string, options PubsubOptions, handler func(ctx context.Context, msg Message, data T) MessageResult) {
+	ps.RegisterMessageHandler(topic, options, func(ctx context.Context, msg Message) MessageResult {
+		var data T
+		if err := msg.Decode(&data); err != nil {
+			return MessageResultDrop(fmt.Errorf("Failed to decode message '%s' with content-type '%s' on topic '%s': %w", msg.Id, msg.ContentType, msg.Topic, err))
+		}
+		return handler(ctx, msg, data)
+	})
+}
+
+// Registers a handler for the topic together with a handler for its dead-letter topic on the same pubsub.
+// The cloud-event options of the topic handler are reused for the dead-letter handler.
+func (ps *pubsub) RegisterMessageHandlerWithDeadLetter(topic string, deadLetterTopic string, options PubsubOptions, handler MessageHandler, deadLetterHandler MessageHandler) {
+	options.DeadLetterTopic = deadLetterTopic
+	ps.RegisterMessageHandler(topic, options, handler)
+	ps.RegisterMessageHandler(deadLetterTopic, PubsubOptions{
+		RawPayload:   options.RawPayload,
+		NoCloudEvent: options.NoCloudEvent,
+	}, deadLetterHandler)
+}
+
+// Groups the registered entries by topic, in order of first registration. Rules are sorted by priority,
+// falling back to registration order for equal priorities.
+func (ps *pubsub) topicSubscriptions() (result []topicSubscription) {
+	topicIndex := make(map[string]int)
+	for i := range ps.entries {
+		entry := ps.entries[i]
+		idx, known := topicIndex[entry.topic]
+		if !known {
+			idx = len(result)
+			topicIndex[entry.topic] = idx
+			result = append(result, topicSubscription{pubsubName: ps.name, topic: entry.topic})
+		}
+		result[idx].entries = append(result[idx].entries, entry)
+		if entry.isRule() {
+			result[idx].rules = append(result[idx].rules, entry)
+		} else {
+			result[idx].defaultEntry = &entry
+		}
+	}
+	for _, sub := range result {
+		sort.SliceStable(sub.rules, func(i, j int) bool {
+			return sub.rules[i].options.Priority < sub.rules[j].options.Priority
+		})
+	}
+	return
+}
 
 type events struct {
-	pubsubs pubsubMap
+	pubsubs    []*pubsub // NOTE: In order of creation, which is the canonical order of subscriptions and routes.
+	middleware []MessageMiddleware
+	errorHook  MessageErrorHook
+	schemas    schemaRegistry
+	codecs     codecRegistry
+
+	duplicatePubsubNames []string // Names passed to NewPubsub more than once, reported by validation.
+}
+
+func (ev *events) SetMessageErrorHook(hook MessageErrorHook) {
+	ev.errorHook = hook
+}
+
+// Adds middleware for the message handlers of all pubsubs. For bulk message handlers the middleware is applied to each
+// message.
+func (ev *events) UseMessageMiddleware(middleware ...MessageMiddleware) {
+	ev.middleware = append(ev.middleware, middleware...)
+}
+
+type SubscriptionRule struct {
+	Match string `json:"match"`
+	Path  string `json:"path"`
+}
+
+type SubscriptionRoutes struct {
+	Rules   []SubscriptionRule `json:"rules,omitempty"`
+	Default string             `json:"default,omitempty"`
+}
+
+type SubscriptionBulkSubscribe struct {
+	Enabled            bool `json:"enabled"`
+	MaxMessagesCount   int  `json:"maxMessagesCount,omitempty"`
+	MaxAwaitDurationMs int  `json:"maxAwaitDurationMs,omitempty"`
+}
+
+// Subscription of the service to a topic, as exposed to the dapr daemon through /dapr/subscribe.
+type Subscription struct {
+	PubsubName      string                     `json:"pubsubname"`
+	Topic           string                     `json:"topic"`
+	Route           string                     `json:"route,omitempty"`  // NOTE: Only set when there are no rules.
+	Routes          *SubscriptionRoutes        `json:"routes,omitempty"` // NOTE: Only set when there are rules.
+	DeadLetterTopic string                     `json:"deadLetterTopic,omitempty"`
+	BulkSubscribe   *SubscriptionBulkSubscribe `json:"bulkSubscribe,omitempty"`
+	Metadata        map[string]string          `json:"metadata"`
+}
+
+func (ev *events) subscriptions(routePrefix string) (result []Subscription) {
+	for _, ps := range ev.pubsubs {
+		for _, sub := range ps.topicSubscriptions() {
+			subscription := Subscription{
+				PubsubName:      sub.pubsubName,
+				Topic:           sub.topic,
+				DeadLetterTopic: sub.deadLetterTopic(),
+				Metadata:        sub.metadata(),
+			}
+			if sub.hasRules() {
+				subscription.Routes = &SubscriptionRoutes{}
+				for _, rule := range sub.rules {
+					subscription.Routes.Rules = append(subscription.Routes.Rules, SubscriptionRule{
+						Match: rule.options.Match,
+						Path:  routePrefix + rule.constructRoute(),
+					})
+				}
+				if sub.defaultEntry != nil {
+					subscription.Routes.Default = routePrefix + sub.defaultEntry.constructRoute()
+				}
+			} else if sub.defaultEntry != nil {
+				subscription.Route = routePrefix + sub.defaultEntry.constructRoute()
+			}
+			if bulkOptions := sub.bulkSubscribe(); bulkOptions != nil {
+				subscription.BulkSubscribe = &SubscriptionBulkSubscribe{
+					Enabled:            true,
+					MaxMessagesCount:   bulkOptions.MaxMessagesCount,
+					MaxAwaitDurationMs: bulkOptions.MaxAwaitDurationMs,
+				}
+			}
+			result = append(result, subscription)
+		}
+	}
+	return
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (ev *events) writePubsubConfigData(w io.Writer, routePrefix string) error {
 	jsw := johanson.NewStreamWriter(w)
 	jsw.Array(func(psa johanson.V) {
-		for _, ps := range ev.pubsubs {
-			for _, entry := range ps.entries {
-				psa.Object(func(pso johanson.K) {
-					pso.Item("pubsubname").String(entry.pubsubName)
-					pso.Item("topic").String(entry.topic)
-					pso.Item("route").String(routePrefix + entry.constructRoute())
-					pso.Item("metadata").Object(func(mdo johanson.K) {
-						if entry.options.RawPayload {
-							mdo.Item("rawPayload").String("true")
+		for _, sub := range ev.subscriptions(routePrefix) {
+			psa.Object(func(pso johanson.K) {
+				pso.Item("pubsubname").String(sub.PubsubName)
+				pso.Item("topic").String(sub.Topic)
+				if sub.Routes != nil {
+					pso.Item("routes").Object(func(ro johanson.K) {
+						ro.Item("rules").Array(func(rla johanson.V) {
+							for _, rule := range sub.Routes.Rules {
+								rla.Object(func(rlo johanson.K) {
+									rlo.Item("match").String(rule.Match)
+									rlo.Item("path").String(rule.Path)
+								})
+							}
+						})
+						if sub.Routes.Default != "" {
+							ro.Item("default").String(sub.Routes.Default)
+						}
+					})
+				} else if sub.Route != "" {
+					pso.Item("route").String(sub.Route)
+				}
+				if sub.DeadLetterTopic != "" {
+					pso.Item("deadLetterTopic").String(sub.DeadLetterTopic)
+				}
+				if bulk := sub.BulkSubscribe; bulk != nil {
+					pso.Item("bulkSubscribe").Object(func(bso johanson.K) {
+						bso.Item("enabled").Bool(bulk.Enabled)
+						if bulk.MaxMessagesCount > 0 {
+							bso.Item("maxMessagesCount").Int(int64(bulk.MaxMessagesCount))
+						}
+						if bulk.MaxAwaitDurationMs > 0 {
+							bso.Item("maxAwaitDurationMs").Int(int64(bulk.MaxAwaitDurationMs))
 						}
 					})
+				}
+				pso.Item("metadata").Object(func(mdo johanson.K) {
+					for _, key := range sortedKeys(sub.Metadata) {
+						mdo.Item(key).String(sub.Metadata[key])
+					}
 				})
-			}
+			})
 		}
 	})
 
@@ -175,7 +656,19 @@ func (ev *events) pubsubEntriesWithRoutes() (result []struct {
 	entry pubsubEntry
 }) {
 	for _, ps := range ev.pubsubs {
+		deadLetterSources := map[string][]string{}
+		for _, entry := range ps.entries {
+			if entry.options.DeadLetterTopic != "" && !slices.Contains(deadLetterSources[entry.options.DeadLetterTopic], entry.topic) {
+				deadLetterSources[entry.options.DeadLetterTopic] = append(deadLetterSources[entry.options.DeadLetterTopic], entry.topic)
+			}
+		}
 		for _, entry := range ps.entries {
+			entry.deadLetterSources = deadLetterSources[entry.topic]
+			if entry.isBulk() {
+				entry.bulkMiddleware = append(append(append([]MessageMiddleware{}, ev.middleware...), ps.middleware...), entry.options.Middleware...)
+			} else {
+				entry.messageHandler = applyMessageMiddleware(entry.messageHandler, ev.middleware, ps.middleware, entry.options.Middleware)
+			}
 			result = append(result, struct {
 				route string
 				entry pubsubEntry
@@ -190,13 +683,76 @@ func (ev *events) pubsubEntriesWithRoutes() (result []struct {
 
 func (ev *events) NewPubsub(name string) *pubsub {
 	ps := &pubsub{name: name}
-	if ev.pubsubs == nil {
-		ev.pubsubs = make(pubsubMap, 10)
+	for i, existing := range ev.pubsubs {
+		if existing.name == name {
+			ev.duplicatePubsubNames = append(ev.duplicatePubsubNames, name)
+			ev.pubsubs[i] = ps
+			return ps
+		}
 	}
-	ev.pubsubs[name] = ps
+	ev.pubsubs = append(ev.pubsubs, ps)
 	return ps
 }
 
+// Reports all problems with the registered pubsubs and message handlers.
+func (ev *events) validate() (errs []error) {
+	for _, name := range ev.duplicatePubsubNames {
+		errs = append(errs, fmt.Errorf("Pubsub '%s' is created more than once, handlers registered before the last creation are dropped.", name))
+	}
+
+	for _, ps := range ev.pubsubs {
+		if ps.name == "" {
+			errs = append(errs, errors.New("Pubsub with empty name."))
+		}
+
+		defaultHandlerCount := make(map[string]int)
+		matchCount := make(map[[2]string]int)
+		bulkTopics := make(map[string]bool)
+		singleTopics := make(map[string]bool)
+		for _, entry := range ps.entries {
+			if entry.topic == "" {
+				errs = append(errs, fmt.Errorf("Pubsub '%s': handler registered for empty topic.", ps.name))
+			}
+			if entry.messageHandler == nil && entry.bulkMessageHandler == nil {
+				errs = append(errs, fmt.Errorf("Pubsub '%s': nil handler registered for topic '%s'.", ps.name, entry.topic))
+			}
+			if entry.options.Schema != "" && !ev.schemas.has(entry.options.Schema) {
+				errs = append(errs, fmt.Errorf("Pubsub '%s': schema '%s' for topic '%s' is not registered.", ps.name, entry.options.Schema, entry.topic))
+			}
+			if entry.options.DeadLetterTopic != "" && entry.options.DeadLetterTopic == entry.topic {
+				errs = append(errs, fmt.Errorf("Pubsub '%s': topic '%s' is its own dead-letter topic.", ps.name, entry.topic))
+			}
+			if entry.isBulk() {
+				bulkTopics[entry.topic] = true
+			} else {
+				singleTopics[entry.topic] = true
+			}
+			if entry.isRule() {
+				matchCount[[2]string{entry.topic, entry.options.Match}]++
+			} else {
+				defaultHandlerCount[entry.topic]++
+			}
+		}
+
+		for _, sub := range ps.topicSubscriptions() {
+			if defaultHandlerCount[sub.topic] > 1 {
+				errs = append(errs, fmt.Errorf("Pubsub '%s': %d handlers without match rule registered for topic '%s'.", ps.name, defaultHandlerCount[sub.topic], sub.topic))
+			}
+			for _, rule := range sub.rules {
+				if count := matchCount[[2]string{sub.topic, rule.options.Match}]; count > 1 {
+					errs = append(errs, fmt.Errorf("Pubsub '%s': %d handlers registered for topic '%s' with match rule '%s'.", ps.name, count, sub.topic, rule.options.Match))
+					matchCount[[2]string{sub.topic, rule.options.Match}] = 0
+				}
+			}
+			if bulkTopics[sub.topic] && singleTopics[sub.topic] {
+				errs = append(errs, fmt.Errorf("Pubsub '%s': both bulk and single message handlers registered for topic '%s'.", ps.name, sub.topic))
+			}
+		}
+	}
+
+	return
+}
+
 var metadataFromHeader = functils.Pipe5(
 	functils.PipeInputType[http.Header],
 	functils.MapEntries,
@@ -204,7 +760,7 @@ var metadataFromHeader = functils.Pipe5(
 		return strings.HasPrefix(strings.ToLower(h.Key), "metadata.")
 	}),
 	functils.SliceTransform(func(h functils.KV[string, []string]) functils.KV[string, string] {
-		return functils.KV[string, string]{h.Key, h.Value[0]}
+		return functils.KV[string, string]{Key: h.Key, Value: h.Value[0]}
 	}),
 	functils.MapFromEntries,
 )
@@ -216,19 +772,167 @@ func (buf *jsonValueBuf) UnmarshalJSON(bytes []byte) error {
 	return nil
 }
 
-func makeEventMessageHandler(entry pubsubEntry) httprouter.Handle {
+// Fills the message from a structured-mode cloud-event envelope.
+func parseCloudEvent(entry pubsubEntry, envelope []byte, msg *Message) error {
+	var cloudEvent CloudEvent
+	jsonErr := json.Unmarshal(envelope, &cloudEvent)
+	if jsonErr != nil {
+		return fmt.Errorf("Failed to unmarshal cloud-event json: %w", jsonErr)
+	}
+
+	if err := fillMessageFromCloudEvent(entry, cloudEvent, true, msg); err != nil {
+		return err
+	}
+	msg.RawEnvelope = envelope
+
+	return nil
+}
+
+// Fills the message from a cloud-event, regardless of the content mode it was received in. The pubsubname and topic
+// attributes are added by the dapr daemon to structured events only, so without requireDestination the destination
+// is only checked when the event has them.
+func fillMessageFromCloudEvent(entry pubsubEntry, cloudEvent CloudEvent, requireDestination bool, msg *Message) error {
+	if cloudEvent.SpecVersion != "1.0" {
+		return fmt.Errorf("Unknown cloud-event spec version '%s'.", cloudEvent.SpecVersion)
+	}
+
+	pubsubName, topic := cloudEvent.extensionString("pubsubname"), cloudEvent.extensionString("topic")
+	hasDestination := requireDestination || pubsubName != "" || topic != ""
+	if hasDestination && (pubsubName != entry.pubsubName || !entry.acceptsTopic(topic)) {
+		return fmt.Errorf("Message arrived at wrong destination (%s/%s) instead of (%s/%s).", entry.pubsubName, entry.topic, pubsubName, topic)
+	}
+
+	msg.Id = cloudEvent.Id
+
+	msg.ContentType = cloudEvent.DataContentType
+
+	data, dataErr := cloudEvent.data()
+	if dataErr != nil {
+		return dataErr
+	}
+	msg.Data = data
+
+	msg.Fields = MessageFields{
+		SpecVersion: cloudEvent.SpecVersion,
+		Source:      cloudEvent.Source,
+		Type:        cloudEvent.Type,
+		Schema:      cloudEvent.DataSchema,
+		Subject:     cloudEvent.Subject,
+		Timestamp:   cloudEvent.Time,
+	}
+	msg.Extensions = cloudEvent.Extensions
+
+	msg.Trace.Id = cloudEvent.extensionString("traceid")
+	msg.Trace.Parent = cloudEvent.extensionString("traceparent")
+	msg.Trace.State = cloudEvent.extensionString("tracestate")
+
+	return nil
+}
+
+// Service wide dependencies of the message handlers.
+type messageHandlerEnv struct {
+	logger      *slog.Logger
+	metrics     *metricsRegistry
+	errorHook   MessageErrorHook
+	maxBodySize int64
+	schemas     *schemaRegistry
+	codecs      *codecRegistry
+}
+
+// Reads the request body, up to the maximum body size of the subscription or else the service.
+func (env messageHandlerEnv) readMessageBody(w http.ResponseWriter, r *http.Request, entry pubsubEntry) ([]byte, error) {
+	limit := entry.options.MaxBodySize
+	if limit <= 0 {
+		limit = env.maxBodySize
+	}
+	if limit <= 0 {
+		return io.ReadAll(r.Body)
+	}
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+}
+
+// Responds with a drop result when the body read error is caused by exceeding the maximum body size.
+func (env messageHandlerEnv) rejectOversizedMessage(w http.ResponseWriter, entry pubsubEntry, bodyErr error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(bodyErr, &maxBytesErr) {
+		return false
+	}
+	env.metrics.messagesRejected.inc(entry.pubsubName, entry.topic, "body_too_large")
+	env.logger.Warn("Message body exceeds maximum size",
+		slog.String("pubsub", entry.pubsubName),
+		slog.String("topic", entry.topic),
+		slog.Int64("maxBodySize", maxBytesErr.Limit),
+	)
+	writeMessageResult(w, MessageResultDrop(fmt.Errorf("Message body exceeds maximum size of %d bytes.", maxBytesErr.Limit)))
+	return true
+}
+
+// Validates the message data against the schema of the message, if any, resulting in a drop result when invalid.
+func (env messageHandlerEnv) validateMessageSchema(entry pubsubEntry, msg Message) MessageResult {
+	schema := entry.messageSchema(env.schemas, msg)
+	if schema == "" {
+		return nil
+	}
+	if err := env.schemas.validate(schema, msg.Data); err != nil {
+		return MessageResultDrop(err)
+	}
+	return nil
+}
+
+func (env messageHandlerEnv) handleResult(ctx context.Context, msg Message, result MessageResult, duration time.Duration) {
+	attrs := []any{
+		slog.String("pubsub", msg.PubsubName),
+		slog.String("topic", msg.Topic),
+		slog.String("messageId", msg.Id),
+		slog.String("traceId", msg.Trace.Id),
+		slog.Duration("duration", duration),
+	}
+	if msg.EntryId != "" {
+		attrs = append(attrs, slog.String("entryId", msg.EntryId))
+	}
+
+	status := messageResultStatus(result)
+	env.metrics.messagesHandled.inc(msg.PubsubName, msg.Topic, status)
+	env.metrics.messageHandlerDuration.observe(duration.Seconds(), msg.PubsubName, msg.Topic, status)
+
+	switch {
+	case result == nil:
+		env.logger.ErrorContext(ctx, "Invalid message handler result", attrs...)
+	case result.Success():
+		env.logger.DebugContext(ctx, "Message handled", append(attrs, slog.String("status", status))...)
+	case result.Drop():
+		env.logger.WarnContext(ctx, "Message dropped", append(attrs, slog.String("status", status), slog.Any("error", result.Error()))...)
+	default:
+		env.logger.ErrorContext(ctx, "Message will be retried", append(attrs, slog.String("status", status), slog.Any("error", result.Error()))...)
+	}
+
+	if env.errorHook != nil && result != nil && !result.Success() {
+		env.errorHook(ctx, msg, result.Error())
+	}
+}
+
+func makeEventMessageHandler(entry pubsubEntry, env messageHandlerEnv) httprouter.Handle {
 	messageParseFail := func(w http.ResponseWriter, err error) {
 		errMsg := fmt.Errorf("Failed to parse event message for pubsub '%s' on topic '%s': %w", entry.pubsubName, entry.topic, err)
-		log.Println(errMsg) // TODO: Allow to inject logger.
+		env.metrics.messageParseFailures.inc(entry.pubsubName, entry.topic)
+		env.logger.Warn("Failed to parse event message",
+			slog.String("pubsub", entry.pubsubName),
+			slog.String("topic", entry.topic),
+			slog.Any("error", err),
+		)
 		w.Header().Add("Content-Type", "text/plain")
 		w.WriteHeader(400)
 		w.Write([]byte(errMsg.Error()))
 	}
 
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		body, bodyErr := io.ReadAll(r.Body)
+		start := time.Now()
+		env.metrics.messagesReceived.inc(entry.pubsubName, entry.topic)
+		body, bodyErr := env.readMessageBody(w, r, entry)
 		if bodyErr != nil {
-			messageParseFail(w, fmt.Errorf("Failed to read message body: %w", bodyErr))
+			if !env.rejectOversizedMessage(w, entry, bodyErr) {
+				messageParseFail(w, fmt.Errorf("Failed to read message body: %w", bodyErr))
+			}
 			return
 		}
 
@@ -241,117 +945,223 @@ func makeEventMessageHandler(entry pubsubEntry) httprouter.Handle {
 			Data:        body,
 			ContentType: "",
 			Metadata:    metadata,
+			codecs:      env.codecs,
 		}
 
 		if !entry.options.NoCloudEvent {
-			if contentType := r.Header.Get("Content-Type"); contentType != "application/cloudevents+json" {
+			contentType := r.Header.Get("Content-Type")
+			if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == cloudEventContentType {
+				if err := parseCloudEvent(entry, body, &msg); err != nil {
+					messageParseFail(w, err)
+					return
+				}
+			} else if isBinaryCloudEvent(r.Header) {
+				if err := fillMessageFromCloudEvent(entry, cloudEventFromBinary(r.Header, body), false, &msg); err != nil {
+					messageParseFail(w, err)
+					return
+				}
+			} else {
 				messageParseFail(w, fmt.Errorf("Message does not have a cloud-event content-type: %s", contentType))
 				return
 			}
+		}
 
-			cloudEvent := struct {
-				Id              string        `json="id"`
-				Source          string        `json="source"`
-				Specversion     string        `json="specversion"`
-				Type            string        `json="type"`
-				Datacontenttype *string       `json="datacontenttype"`
-				Dataschema      *string       `json="dataschema"`
-				Subject         *string       `json="subject"`
-				Time            *string       `json="time"`
-				Data            *jsonValueBuf `json="data"`
-				Data_base64     *string       `json="data_base64"`
-
-				// Extension fields from dapr daemon:
-				Pubsubname  string `json="pubsubname"`
-				Topic       string `json="topic"`
-				Traceid     string `json="traceid"`
-				Traceparent string `json="traceparent"`
-				Tracestate  string `json="tracestate"`
-			}{}
-
-			jsonErr := json.Unmarshal(body, &cloudEvent)
-			if jsonErr != nil {
-				messageParseFail(w, fmt.Errorf("Failed to unmarshal cloud-event json: %w", jsonErr))
-				return
-			}
+		ctx := contextWithMessageTrace(r.Context(), msg, r.Header)
+		result := env.validateMessageSchema(entry, msg)
+		if result == nil {
+			result = callMessageHandler(ctx, env, entry, msg)
+		}
+		env.handleResult(ctx, msg, result, time.Since(start))
 
-			if cloudEvent.Specversion != "1.0" {
-				messageParseFail(w, fmt.Errorf("Unknown cloud-event spec version '%s'.", cloudEvent.Specversion))
-				return
+		writeMessageResult(w, result)
+	}
+}
+
+func writeMessageResult(w http.ResponseWriter, result MessageResult) {
+	switch {
+	case result == nil:
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid message handler result."))
+	case result.Success():
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write([]byte(`{"status":"SUCCESS"}`))
+	case result.Retry():
+		w.Header().Add("Content-Type", "application/json")
+		retryErr := result.Error()
+		w.WriteHeader(500)
+		jw := johanson.NewStreamWriter(w)
+		jw.Object(func(o johanson.K) {
+			o.Item("status").String("RETRY")
+			if retryErr != nil {
+				o.Item("error").String(retryErr.Error())
+			}
+		})
+	case result.Drop():
+		w.Header().Add("Content-Type", "application/json")
+		dropErr := result.Error()
+		w.WriteHeader(400)
+		jw := johanson.NewStreamWriter(w)
+		jw.Object(func(o johanson.K) {
+			o.Item("status").String("DROP")
+			if dropErr != nil {
+				o.Item("error").String(dropErr.Error())
 			}
+		})
+	default:
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid message handler result."))
+	}
+}
 
-			if cloudEvent.Pubsubname != entry.pubsubName || cloudEvent.Topic != entry.topic {
-				messageParseFail(w, fmt.Errorf("Message arrived at wrong destination (%s/%s) instead of (%s/%s).", entry.pubsubName, entry.topic, cloudEvent.Pubsubname, cloudEvent.Topic))
-				return
+func messageResultStatus(result MessageResult) string {
+	switch {
+	case result == nil:
+		return "RETRY"
+	case result.Success():
+		return "SUCCESS"
+	case result.Drop():
+		return "DROP"
+	default:
+		return "RETRY"
+	}
+}
+
+func makeBulkEventMessageHandler(entry pubsubEntry, env messageHandlerEnv) httprouter.Handle {
+	messageParseFail := func(w http.ResponseWriter, err error) {
+		errMsg := fmt.Errorf("Failed to parse bulk event message for pubsub '%s' on topic '%s': %w", entry.pubsubName, entry.topic, err)
+		env.metrics.messageParseFailures.inc(entry.pubsubName, entry.topic)
+		env.logger.Warn("Failed to parse bulk event message",
+			slog.String("pubsub", entry.pubsubName),
+			slog.String("topic", entry.topic),
+			slog.Any("error", err),
+		)
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(errMsg.Error()))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		start := time.Now()
+		body, bodyErr := env.readMessageBody(w, r, entry)
+		if bodyErr != nil {
+			if !env.rejectOversizedMessage(w, entry, bodyErr) {
+				messageParseFail(w, fmt.Errorf("Failed to read message body: %w", bodyErr))
 			}
+			return
+		}
 
-			msg.Id = cloudEvent.Id
+		bulkMessage := struct {
+			Entries []struct {
+				EntryId     string            `json:"entryId"`
+				Event       jsonValueBuf      `json:"event"`
+				ContentType string            `json:"contentType"`
+				Metadata    map[string]string `json:"metadata"`
+			} `json:"entries"`
+			Metadata map[string]string `json:"metadata"`
+		}{}
 
-			msg.ContentType = functils.DefaultOnNil(cloudEvent.Datacontenttype)
+		if jsonErr := json.Unmarshal(body, &bulkMessage); jsonErr != nil {
+			messageParseFail(w, fmt.Errorf("Failed to unmarshal bulk message json: %w", jsonErr))
+			return
+		}
 
-			if msg.ContainsJsonData() {
-				msg.Data = *cloudEvent.Data
-			} else if dataBase64 := cloudEvent.Data_base64; dataBase64 != nil {
-				data, err := base64.StdEncoding.DecodeString(*dataBase64)
-				if err != nil {
-					messageParseFail(w, fmt.Errorf("Failed to decode cloud-event base64 data."))
-					return
+		env.metrics.messagesReceived.add(uint64(len(bulkMessage.Entries)), entry.pubsubName, entry.topic)
+
+		results := make(map[string]MessageResult, len(bulkMessage.Entries))
+		messages := make([]Message, 0, len(bulkMessage.Entries))
+		invalidMessages := []Message{}
+		for _, bulkEntry := range bulkMessage.Entries {
+			metadata := make(map[string]string, len(bulkMessage.Metadata)+len(bulkEntry.Metadata))
+			for k, v := range bulkMessage.Metadata {
+				metadata[k] = v
+			}
+			for k, v := range bulkEntry.Metadata {
+				metadata[k] = v
+			}
+
+			msg := Message{
+				PubsubName:  entry.pubsubName,
+				Topic:       entry.topic,
+				EntryId:     bulkEntry.EntryId,
+				Data:        bulkEntry.Event,
+				ContentType: bulkEntry.ContentType,
+				Metadata:    metadata,
+				codecs:      env.codecs,
+			}
+
+			if !entry.options.NoCloudEvent && mediaTypeOf(bulkEntry.ContentType) == cloudEventContentType {
+				if err := parseCloudEvent(entry, bulkEntry.Event, &msg); err != nil {
+					env.metrics.messageParseFailures.inc(entry.pubsubName, entry.topic)
+					env.logger.Warn("Failed to parse bulk event message entry",
+						slog.String("pubsub", entry.pubsubName),
+						slog.String("topic", entry.topic),
+						slog.String("entryId", bulkEntry.EntryId),
+						slog.Any("error", err),
+					)
+					results[bulkEntry.EntryId] = MessageResultDrop(err)
+					continue
 				}
-				msg.Data = data
 			} else {
-				messageParseFail(w, fmt.Errorf("Cloud-event data does not match content type."))
-				return
+				// NOTE: Raw payloads are delivered as a base64 encoded json string.
+				var rawEvent string
+				if json.Unmarshal(bulkEntry.Event, &rawEvent) == nil {
+					if data, err := base64.StdEncoding.DecodeString(rawEvent); err == nil {
+						msg.Data = data
+					} else {
+						msg.Data = []byte(rawEvent)
+					}
+				}
 			}
 
-			msg.Fields = MessageFields{
-				Source:    cloudEvent.Source,
-				Type:      cloudEvent.Type,
-				Schema:    functils.DefaultOnNil(cloudEvent.Dataschema),
-				Subject:   functils.DefaultOnNil(cloudEvent.Subject),
-				Timestamp: functils.DefaultOnErr(func(t string) (time.Time, error) { return time.Parse(time.RFC3339, t) })(functils.DefaultOnNil(cloudEvent.Time)),
+			if result := env.validateMessageSchema(entry, msg); result != nil {
+				results[msg.EntryId] = result
+				invalidMessages = append(invalidMessages, msg)
+				continue
 			}
 
-			msg.Trace.Id = cloudEvent.Traceid
-			msg.Trace.Parent = cloudEvent.Traceparent
-			msg.Trace.State = cloudEvent.Tracestate
+			messages = append(messages, msg)
 		}
 
-		result := entry.messageHandler(r.Context(), msg)
+		if len(messages) > 0 {
+			ctx := r.Context()
+			if tc, err := TraceContextFromHeader(r.Header); err == nil {
+				ctx = ContextWithTrace(ctx, tc)
+			}
+			call := callBulkMessageHandler
+			if len(entry.bulkMiddleware) > 0 {
+				call = callBulkMessageHandlerThroughMiddleware
+			}
+			for entryId, result := range call(ctx, env, entry, messages) {
+				results[entryId] = result
+			}
+		}
 
-		switch {
-		case result.Success():
-			// TODO: Log info.
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(200)
-			w.Write([]byte(`{"status":"SUCCESS"}`))
-		case result.Retry():
-			w.Header().Add("Content-Type", "application/json")
-			// TODO: Log error.
-			retryErr := result.Error()
-			w.WriteHeader(500)
-			jw := johanson.NewStreamWriter(w)
-			jw.Object(func(o johanson.K) {
-				o.Item("status").String("RETRY")
-				if retryErr != nil {
-					o.Item("error").String(retryErr.Error())
-				}
-			})
-		case result.Drop():
-			w.Header().Add("Content-Type", "application/json")
-			// TODO: Log error.
-			dropErr := result.Error()
-			w.WriteHeader(400)
-			jw := johanson.NewStreamWriter(w)
-			jw.Object(func(o johanson.K) {
-				o.Item("status").String("DROP")
-				if dropErr != nil {
-					o.Item("error").String(dropErr.Error())
+		duration := time.Since(start)
+		for _, msg := range append(messages, invalidMessages...) {
+			env.handleResult(r.Context(), msg, results[msg.EntryId], duration)
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(200)
+		jw := johanson.NewStreamWriter(w)
+		jw.Object(func(o johanson.K) {
+			o.Item("statuses").Array(func(sa johanson.V) {
+				for _, bulkEntry := range bulkMessage.Entries {
+					result, found := results[bulkEntry.EntryId]
+					sa.Object(func(so johanson.K) {
+						so.Item("entryId").String(bulkEntry.EntryId)
+						so.Item("status").String(messageResultStatus(result))
+						if !found {
+							so.Item("error").String("No result for bulk message entry.")
+						} else if result != nil && !result.Success() && result.Error() != nil {
+							so.Item("error").String(result.Error().Error())
+						}
+					})
 				}
 			})
-		default:
-			w.Header().Add("Content-Type", "text/plain")
-			w.WriteHeader(400)
-			w.Write([]byte("Invalid message handler result."))
-		}
+		})
 	}
 }
@@ -1,6 +1,8 @@
 package daprsvc
 
 import (
+	"bytes"
+	"fmt"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -9,21 +11,67 @@ import (
 func (svc *daprSvc) HttpHandler() http.Handler {
 	router := httprouter.New()
 
+	if svc.fallbackHandler != nil {
+		router.NotFound = svc.fallbackHandler
+		router.MethodNotAllowed = svc.fallbackHandler
+	}
+
 	// Events
-	messageHandlerRoutePrefix := "/message"
+	messageHandlerRoutePrefix := svc.messageHandlerRoutePrefix()
 
-	router.HandlerFunc(http.MethodGet, "/dapr/subscribe", func(w http.ResponseWriter, r *http.Request) {
+	// NOTE: Registrations made after building the handler are not served, so the subscriptions are fixed here.
+	pubsubConfigData := &bytes.Buffer{}
+	pubsubConfigErr := svc.writePubsubConfigData(pubsubConfigData, messageHandlerRoutePrefix)
+	router.HandlerFunc(http.MethodGet, svc.basePath+"/dapr/subscribe", func(w http.ResponseWriter, r *http.Request) {
+		if pubsubConfigErr != nil {
+			http.Error(w, pubsubConfigErr.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Add("Content-Type", "application/json")
-		svc.writePubsubConfigData(w, messageHandlerRoutePrefix)
+		w.Write(pubsubConfigData.Bytes())
 	})
 
+	env := messageHandlerEnv{
+		logger:      svc.getLogger(),
+		metrics:     svc.metrics,
+		errorHook:   svc.errorHook,
+		maxBodySize: svc.maxMessageBodySize,
+		schemas:     &svc.schemas,
+		codecs:      &svc.codecs,
+	}
+
 	for _, mwr := range svc.pubsubEntriesWithRoutes() {
 		entry := mwr.entry
-		router.POST(messageHandlerRoutePrefix+mwr.route, makeEventMessageHandler(entry))
+		if entry.isBulk() {
+			router.POST(messageHandlerRoutePrefix+mwr.route, makeBulkEventMessageHandler(entry, env))
+		} else {
+			router.POST(messageHandlerRoutePrefix+mwr.route, makeEventMessageHandler(entry, env))
+		}
+	}
+
+	// Metrics
+	if svc.metricsRoute != "" {
+		router.Handler(http.MethodGet, svc.basePath+svc.metricsRoute, svc.metrics)
 	}
 
 	// Invocation
-	routerWithInterceptor := svc.makeInvocationRequestInterceptor(router)
+	routerWithInterceptor := svc.makeInvocationRequestInterceptor(router, svc.getLogger(), svc.metrics)
 
 	return routerWithInterceptor
 }
+
+// Validates the registrations before creating the http handler, instead of failing while serving requests.
+func (svc *daprSvc) BuildHttpHandler() (handler http.Handler, err error) {
+	if err := svc.Validate(); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			handler = nil
+			err = fmt.Errorf("Failed to build http handler: %v", recovered)
+		}
+	}()
+
+	return svc.HttpHandler(), nil
+}
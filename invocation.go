@@ -1,9 +1,20 @@
 package daprsvc
 
-import "net/http"
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Route label of invocation metrics when no route labeler is set, or the labeler returns an empty label.
+const defaultInvocationRouteLabel = "*"
 
 type invocation struct {
-	handler http.Handler
+	handler      http.Handler
+	routeLabeler func(r *http.Request) string
 }
 
 func detectInvocationRequest(r *http.Request) bool {
@@ -21,15 +32,96 @@ func detectInvocationRequest(r *http.Request) bool {
 	return true
 }
 
-func (inv *invocation) makeInvocationRequestInterceptor(alternativeHandler http.Handler) http.HandlerFunc {
+// Keeps track of the response status written by a handler. Flushing, hijacking and ReadFrom are passed on to the
+// underlying response writer, so handlers can stream responses or upgrade connections.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+func (sr *statusRecorder) Flush() {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && sr.status == 0 {
+		sr.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (sr *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	if readerFrom, ok := sr.ResponseWriter.(io.ReaderFrom); ok {
+		return readerFrom.ReadFrom(src)
+	}
+	// NOTE: Hide the ReadFrom method from io.Copy, which would call it again.
+	return io.Copy(struct{ io.Writer }{sr.ResponseWriter}, src)
+}
+
+func (inv *invocation) makeInvocationRequestInterceptor(alternativeHandler http.Handler, logger *slog.Logger, metrics *metricsRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if detectInvocationRequest(r) {
-			w.Header().Set("X-Daprsvc-Invocation", "1")
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			rec.Header().Set("X-Daprsvc-Invocation", "1")
+			tc, traceErr := TraceContextFromHeader(r.Header)
+			if traceErr == nil {
+				r = r.WithContext(ContextWithTrace(r.Context(), tc))
+			}
 			if inv.handler != nil {
-				inv.handler.ServeHTTP(w, r)
+				inv.handler.ServeHTTP(rec, r)
 			} else {
-				http.NotFoundHandler().ServeHTTP(w, r)
+				http.NotFoundHandler().ServeHTTP(rec, r)
 			}
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			duration := time.Since(start)
+			metrics.observeInvocationRequest(r.Method, inv.routeLabel(r), rec.status, duration)
+			// NOTE: Successful requests are only logged at debug level, to not flood the logs.
+			level := slog.LevelDebug
+			if rec.status >= 400 {
+				level = slog.LevelInfo
+			}
+			logger.Log(r.Context(), level, "Invocation request handled",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("callerAppId", r.Header.Get("Dapr-Caller-App-Id")),
+				slog.String("traceId", tc.TraceId),
+				slog.Int("status", rec.status),
+				slog.Duration("duration", duration),
+			)
 		} else {
 			alternativeHandler.ServeHTTP(w, r)
 		}
@@ -39,3 +131,20 @@ func (inv *invocation) makeInvocationRequestInterceptor(alternativeHandler http.
 func (inv *invocation) SetInvocationHandler(handler http.Handler) {
 	inv.handler = handler
 }
+
+// Sets the function deriving the route label of the invocation metrics from a request, like "/orders/:id" for
+// "/orders/1". The labels must have few distinct values, so request paths with parameters must not be used as is.
+// Without labeler all invocation requests share one route label.
+func (inv *invocation) SetInvocationRouteLabeler(labeler func(r *http.Request) string) {
+	inv.routeLabeler = labeler
+}
+
+func (inv *invocation) routeLabel(r *http.Request) string {
+	if inv.routeLabeler == nil {
+		return defaultInvocationRouteLabel
+	}
+	if label := inv.routeLabeler(r); label != "" {
+		return label
+	}
+	return defaultInvocationRouteLabel
+}
@@ -0,0 +1,100 @@
+package daprsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type InvokeRequest struct {
+	HttpMethod string // NOTE: The http verb, POST by default.
+	Header     http.Header
+	Query      url.Values
+	Body       []byte
+}
+
+type InvokeResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Error of an invocation to which the called app, or the dapr daemon on its behalf, responded with a non-2xx status.
+type InvocationError struct {
+	AppId      string
+	Method     string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Err        *SidecarError
+}
+
+func (err *InvocationError) Error() string {
+	return fmt.Sprintf("Invocation of method '%s' of app '%s' failed: %s", err.Method, err.AppId, err.Err)
+}
+
+func (err *InvocationError) Unwrap() error {
+	return err.Err
+}
+
+// Escapes the segments of the method, which may consist of multiple path segments.
+func invokePath(appId string, method string) string {
+	segments := strings.Split(strings.TrimPrefix(method, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/v1.0/invoke/" + url.PathEscape(appId) + "/method/" + strings.Join(segments, "/")
+}
+
+// Invokes the method of the app through the dapr daemon. A non-2xx response results in an *InvocationError.
+func (sc *SidecarClient) InvokeMethod(ctx context.Context, appId string, method string, req InvokeRequest) (*InvokeResponse, error) {
+	httpMethod := req.HttpMethod
+	if httpMethod == "" {
+		httpMethod = http.MethodPost
+	}
+
+	resp, body, err := sc.do(ctx, httpMethod, invokePath(appId, method), req.Query, req.Header, req.Body)
+	var sidecarErr *SidecarError
+	if errors.As(err, &sidecarErr) {
+		return nil, &InvocationError{AppId: appId, Method: method, StatusCode: resp.StatusCode, Header: resp.Header, Body: body, Err: sidecarErr}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invocation of method '%s' of app '%s' failed: %w", method, appId, err)
+	}
+	return &InvokeResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}, nil
+}
+
+// Invokes the method of the app with the request encoded as json, and decodes the json response. No body is sent
+// for GET and HEAD requests, and an empty response leaves the result at its zero value.
+func Invoke[Req any, Resp any](ctx context.Context, sc *SidecarClient, httpMethod string, appId string, method string, request Req) (response Resp, err error) {
+	req := InvokeRequest{
+		HttpMethod: httpMethod,
+		Header:     http.Header{"Accept": {"application/json"}},
+	}
+	if httpMethod != http.MethodGet && httpMethod != http.MethodHead {
+		if req.Body, err = json.Marshal(request); err != nil {
+			return response, fmt.Errorf("Failed to encode request for method '%s' of app '%s': %w", method, appId, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := sc.InvokeMethod(ctx, appId, method, req)
+	if err != nil {
+		return response, err
+	}
+	if len(resp.Body) > 0 {
+		if err := json.Unmarshal(resp.Body, &response); err != nil {
+			return response, fmt.Errorf("Failed to decode response of method '%s' of app '%s': %w", method, appId, err)
+		}
+	}
+	return response, nil
+}
+
+// Invokes the method of the app with the client for the dapr daemon of the service.
+func (svc *daprSvc) InvokeMethod(ctx context.Context, appId string, method string, req InvokeRequest) (*InvokeResponse, error) {
+	return svc.sidecar.InvokeMethod(ctx, appId, method, req)
+}
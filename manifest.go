@@ -0,0 +1,121 @@
+package daprsvc
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type ManifestOptions struct {
+	NamePrefix string   // NOTE: Prepended to the generated resource names, e.g. the app id.
+	Namespace  string   // NOTE: Namespace of the resources; omitted when empty.
+	Scopes     []string // NOTE: App ids allowed to use the subscriptions; omitted when empty.
+}
+
+var regexInvalidResourceNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Derives a valid resource name (a DNS-1123 subdomain) from the parts.
+func manifestResourceName(parts ...string) string {
+	name := regexInvalidResourceNameChars.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 240 {
+		name = strings.Trim(name[:240], "-")
+	}
+	if name == "" {
+		name = "subscription"
+	}
+	return name
+}
+
+// Quotes a string as YAML double-quoted scalar, whose escape sequences are a superset of the ones used by Go.
+func yamlQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+// Writes the subscriptions as declarative dapr.io/v2alpha1 Subscription resources, in a multi-document YAML stream.
+func WriteSubscriptionManifests(w io.Writer, subscriptions []Subscription, options ManifestOptions) error {
+	var sb strings.Builder
+	usedNames := make(map[string]bool)
+
+	for i, sub := range subscriptions {
+		nameParts := []string{sub.PubsubName, sub.Topic}
+		if options.NamePrefix != "" {
+			nameParts = append([]string{options.NamePrefix}, nameParts...)
+		}
+		name := manifestResourceName(nameParts...)
+		for n := 2; usedNames[name]; n++ {
+			name = manifestResourceName(append(nameParts, strconv.Itoa(n))...)
+		}
+		usedNames[name] = true
+
+		if i > 0 {
+			sb.WriteString("---\n")
+		}
+		sb.WriteString("apiVersion: dapr.io/v2alpha1\n")
+		sb.WriteString("kind: Subscription\n")
+		sb.WriteString("metadata:\n")
+		fmt.Fprintf(&sb, "  name: %s\n", name)
+		if options.Namespace != "" {
+			fmt.Fprintf(&sb, "  namespace: %s\n", yamlQuote(options.Namespace))
+		}
+		sb.WriteString("spec:\n")
+		fmt.Fprintf(&sb, "  pubsubname: %s\n", yamlQuote(sub.PubsubName))
+		fmt.Fprintf(&sb, "  topic: %s\n", yamlQuote(sub.Topic))
+
+		// NOTE: The v2alpha1 resource only knows routes, a single route becomes the default route.
+		sb.WriteString("  routes:\n")
+		if sub.Routes != nil {
+			if len(sub.Routes.Rules) > 0 {
+				sb.WriteString("    rules:\n")
+				for _, rule := range sub.Routes.Rules {
+					fmt.Fprintf(&sb, "      - match: %s\n", yamlQuote(rule.Match))
+					fmt.Fprintf(&sb, "        path: %s\n", yamlQuote(rule.Path))
+				}
+			}
+			if sub.Routes.Default != "" {
+				fmt.Fprintf(&sb, "    default: %s\n", yamlQuote(sub.Routes.Default))
+			}
+		} else {
+			fmt.Fprintf(&sb, "    default: %s\n", yamlQuote(sub.Route))
+		}
+
+		if sub.DeadLetterTopic != "" {
+			fmt.Fprintf(&sb, "  deadLetterTopic: %s\n", yamlQuote(sub.DeadLetterTopic))
+		}
+
+		if bulk := sub.BulkSubscribe; bulk != nil {
+			sb.WriteString("  bulkSubscribe:\n")
+			fmt.Fprintf(&sb, "    enabled: %t\n", bulk.Enabled)
+			if bulk.MaxMessagesCount > 0 {
+				fmt.Fprintf(&sb, "    maxMessagesCount: %d\n", bulk.MaxMessagesCount)
+			}
+			if bulk.MaxAwaitDurationMs > 0 {
+				fmt.Fprintf(&sb, "    maxAwaitDurationMs: %d\n", bulk.MaxAwaitDurationMs)
+			}
+		}
+
+		if len(sub.Metadata) > 0 {
+			sb.WriteString("  metadata:\n")
+			for _, key := range sortedKeys(sub.Metadata) {
+				fmt.Fprintf(&sb, "    %s: %s\n", yamlQuote(key), yamlQuote(sub.Metadata[key]))
+			}
+		}
+
+		if len(options.Scopes) > 0 {
+			sb.WriteString("scopes:\n")
+			for _, scope := range options.Scopes {
+				fmt.Fprintf(&sb, "  - %s\n", yamlQuote(scope))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Writes the registered subscriptions of the service as declarative Subscription resources.
+func (svc *daprSvc) WriteSubscriptionManifests(w io.Writer, options ManifestOptions) error {
+	return WriteSubscriptionManifests(w, svc.Subscriptions(), options)
+}
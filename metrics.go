@@ -0,0 +1,203 @@
+package daprsvc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricKindCounter   = "counter"
+	metricKindHistogram = "histogram"
+)
+
+var defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricSeries struct {
+	labelValues  []string
+	count        uint64 // NOTE: Counter value, or number of observations for a histogram.
+	sum          float64
+	bucketCounts []uint64
+}
+
+type metricFamily struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newMetricFamily(name string, help string, kind string, labelNames ...string) *metricFamily {
+	mf := &metricFamily{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]*metricSeries),
+	}
+	if kind == metricKindHistogram {
+		mf.buckets = defaultDurationBuckets
+	}
+	return mf
+}
+
+// Must be called with the lock held.
+func (mf *metricFamily) getSeries(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\x00")
+	series, found := mf.series[key]
+	if !found {
+		series = &metricSeries{labelValues: labelValues}
+		if mf.kind == metricKindHistogram {
+			series.bucketCounts = make([]uint64, len(mf.buckets))
+		}
+		mf.series[key] = series
+	}
+	return series
+}
+
+func (mf *metricFamily) add(delta uint64, labelValues ...string) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	mf.getSeries(labelValues).count += delta
+}
+
+func (mf *metricFamily) inc(labelValues ...string) {
+	mf.add(1, labelValues...)
+}
+
+func (mf *metricFamily) observe(value float64, labelValues ...string) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	series := mf.getSeries(labelValues)
+	series.count++
+	series.sum += value
+	for i, upperBound := range mf.buckets {
+		if value <= upperBound {
+			series.bucketCounts[i]++
+		}
+	}
+}
+
+var metricLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var metricHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatMetricLabels(names []string, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, metricLabelValueEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], metricLabelValueEscaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Writes the metric family in the Prometheus text exposition format.
+func (mf *metricFamily) write(w io.Writer) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	keys := make([]string, 0, len(mf.series))
+	for key := range mf.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# HELP %s %s\n", mf.name, metricHelpEscaper.Replace(mf.help))
+	fmt.Fprintf(&sb, "# TYPE %s %s\n", mf.name, mf.kind)
+	for _, key := range keys {
+		series := mf.series[key]
+		switch mf.kind {
+		case metricKindCounter:
+			fmt.Fprintf(&sb, "%s%s %d\n", mf.name, formatMetricLabels(mf.labelNames, series.labelValues), series.count)
+		case metricKindHistogram:
+			for i, upperBound := range mf.buckets {
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", mf.name, formatMetricLabels(mf.labelNames, series.labelValues, "le", formatMetricValue(upperBound)), series.bucketCounts[i])
+			}
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", mf.name, formatMetricLabels(mf.labelNames, series.labelValues, "le", "+Inf"), series.count)
+			fmt.Fprintf(&sb, "%s_sum%s %s\n", mf.name, formatMetricLabels(mf.labelNames, series.labelValues), formatMetricValue(series.sum))
+			fmt.Fprintf(&sb, "%s_count%s %d\n", mf.name, formatMetricLabels(mf.labelNames, series.labelValues), series.count)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Metrics for message processing and invocation traffic of the service.
+type metricsRegistry struct {
+	messagesReceived          *metricFamily
+	messagesHandled           *metricFamily
+	messageParseFailures      *metricFamily
+	messagesRejected          *metricFamily
+	messageHandlerDuration    *metricFamily
+	invocationRequests        *metricFamily
+	invocationRequestDuration *metricFamily
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		messagesReceived: newMetricFamily("daprsvc_messages_received_total",
+			"Number of pubsub messages received.", metricKindCounter, "pubsub", "topic"),
+		messagesHandled: newMetricFamily("daprsvc_messages_handled_total",
+			"Number of pubsub messages handled, by result status.", metricKindCounter, "pubsub", "topic", "status"),
+		messageParseFailures: newMetricFamily("daprsvc_message_parse_failures_total",
+			"Number of pubsub messages that failed to parse.", metricKindCounter, "pubsub", "topic"),
+		messagesRejected: newMetricFamily("daprsvc_messages_rejected_total",
+			"Number of pubsub messages rejected before parsing, by reason.", metricKindCounter, "pubsub", "topic", "reason"),
+		messageHandlerDuration: newMetricFamily("daprsvc_message_handler_duration_seconds",
+			"Duration of pubsub message handling in seconds.", metricKindHistogram, "pubsub", "topic", "status"),
+		invocationRequests: newMetricFamily("daprsvc_invocation_requests_total",
+			"Number of invocation requests, by response status code.", metricKindCounter, "method", "route", "code"),
+		invocationRequestDuration: newMetricFamily("daprsvc_invocation_request_duration_seconds",
+			"Duration of invocation requests in seconds.", metricKindHistogram, "method", "route"),
+	}
+}
+
+func (mr *metricsRegistry) families() []*metricFamily {
+	return []*metricFamily{
+		mr.messagesReceived,
+		mr.messagesHandled,
+		mr.messageParseFailures,
+		mr.messagesRejected,
+		mr.messageHandlerDuration,
+		mr.invocationRequests,
+		mr.invocationRequestDuration,
+	}
+}
+
+func (mr *metricsRegistry) observeInvocationRequest(method string, route string, status int, duration time.Duration) {
+	mr.invocationRequests.inc(method, route, strconv.Itoa(status))
+	mr.invocationRequestDuration.observe(duration.Seconds(), method, route)
+}
+
+func (mr *metricsRegistry) writeText(w io.Writer) error {
+	for _, mf := range mr.families() {
+		if err := mf.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mr *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mr.writeText(w)
+}
@@ -8,10 +8,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	daprsvc "github.com/tbknl/go-sdk-daprsvc"
 )
@@ -99,6 +107,46 @@ func Test_Invocation(t *testing.T) {
 	}
 }
 
+func Test_InvocationResponseWriterInterfaces(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var isFlusher, isHijacker bool
+	var hijackErr error
+	svc.SetInvocationHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var flusher http.Flusher
+		flusher, isFlusher = w.(http.Flusher)
+		var hijacker http.Hijacker
+		if hijacker, isHijacker = w.(http.Hijacker); isHijacker {
+			_, _, hijackErr = hijacker.Hijack()
+		}
+		w.Write([]byte("Hello, "))
+		if isFlusher {
+			flusher.Flush()
+		}
+		io.Copy(w, strings.NewReader("world"))
+	}))
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Add("Dapr-Caller-App-Id", "test")
+	req.Header.Add("Dapr-Callee-App-Id", "daprsvc")
+	wrec := httptest.NewRecorder()
+	svc.HttpHandler().ServeHTTP(wrec, req)
+
+	if !isFlusher {
+		t.Errorf("Expected the response writer of an invocation handler to be a http.Flusher")
+	}
+	if !wrec.Flushed {
+		t.Errorf("Expected the response to be flushed")
+	}
+	if !isHijacker || !errors.Is(hijackErr, http.ErrNotSupported) {
+		t.Errorf("Expected hijacking to be passed on and not supported by the recorder, got error '%v'", hijackErr)
+	}
+	if want, got := "Hello, world", wrec.Body.String(); want != got {
+		t.Errorf("Expected response body '%s' got '%s'", want, got)
+	}
+}
+
 func Test_DaprSubscribeNoMessageHandlers(t *testing.T) {
 	svc := daprsvc.New()
 
@@ -339,3 +387,2085 @@ func Test_DaprSubscribeMessageHandler(t *testing.T) {
 		}
 	}
 }
+
+func Test_DaprSubscribeRules(t *testing.T) {
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	}
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{Match: `event.type == "order.cancelled"`, Priority: 2}, noopHandler)
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{}, noopHandler)
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{Match: `event.type == "order.created"`, Priority: 1}, noopHandler)
+
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/dapr/subscribe", nil)
+	svc.HttpHandler().ServeHTTP(wrec, req)
+	result := wrec.Result()
+
+	body, _ := io.ReadAll(result.Body)
+	expected := `[{
+		"pubsubname": "servicebus",
+		"topic": "order",
+		"routes": {
+			"rules": [
+				{"match": "event.type == \"order.created\"", "path": "/message/servicebus/order/rule/2"},
+				{"match": "event.type == \"order.cancelled\"", "path": "/message/servicebus/order/rule/0"}
+			],
+			"default": "/message/servicebus/order"
+		},
+		"metadata": {}
+	}]`
+	if want, got := equalJson, IsEqualJson(expected, body); want != got {
+		t.Errorf("Expected body to equal '%s' got '%s'", expected, string(body))
+	}
+}
+
+func Test_DaprSubscribeMessageRuleRouting(t *testing.T) {
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+	handled := ""
+	makeHandler := func(name string) daprsvc.MessageHandler {
+		return func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+			handled = name
+			return daprsvc.MessageResultSuccess()
+		}
+	}
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{NoCloudEvent: true}, makeHandler("default"))
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{NoCloudEvent: true, Match: `event.type == "order.created"`}, makeHandler("created"))
+
+	testCases := []struct {
+		path            string
+		expectedHandler string
+	}{
+		{path: "/message/servicebus/order", expectedHandler: "default"},
+		{path: "/message/servicebus/order/rule/1", expectedHandler: "created"},
+	}
+
+	handler := svc.HttpHandler()
+	for i, tc := range testCases {
+		handled = ""
+		wrec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", tc.path, bytes.NewBufferString("{}"))
+		handler.ServeHTTP(wrec, req)
+
+		if want, got := 200, wrec.Result().StatusCode; want != got {
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d'", i, want, got)
+		}
+		if want, got := tc.expectedHandler, handled; want != got {
+			t.Errorf("Test case %d: Expected message to be handled by '%s' got '%s'", i, want, got)
+		}
+	}
+}
+
+func Test_DaprSubscribeDeadLetterTopic(t *testing.T) {
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	}
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{DeadLetterTopic: "order-failed"}, noopHandler)
+	ps.RegisterMessageHandlerWithDeadLetter("payment", "payment-failed", daprsvc.PubsubOptions{RawPayload: true}, noopHandler, noopHandler)
+
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/dapr/subscribe", nil)
+	svc.HttpHandler().ServeHTTP(wrec, req)
+	result := wrec.Result()
+
+	body, _ := io.ReadAll(result.Body)
+	expected := `[
+		{"pubsubname":"servicebus","topic":"order","route":"/message/servicebus/order","deadLetterTopic":"order-failed","metadata":{}},
+		{"pubsubname":"servicebus","topic":"payment","route":"/message/servicebus/payment","deadLetterTopic":"payment-failed","metadata":{"rawPayload":"true"}},
+		{"pubsubname":"servicebus","topic":"payment-failed","route":"/message/servicebus/payment-failed","metadata":{"rawPayload":"true"}}
+	]`
+	if want, got := equalJson, IsEqualJson(expected, body); want != got {
+		t.Errorf("Expected body to equal '%s' got '%s'", expected, string(body))
+	}
+}
+
+func Test_DaprSubscribeDeadLetterMessage(t *testing.T) {
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	}
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{DeadLetterTopic: "order-failed"}, noopHandler)
+	ps.RegisterMessageHandler("order-failed", daprsvc.PubsubOptions{}, noopHandler)
+	ps.RegisterMessageHandlerWithDeadLetter("payment", "payment-failed", daprsvc.PubsubOptions{}, noopHandler, noopHandler)
+
+	testCases := []struct {
+		route          string
+		topic          string
+		expectedStatus int
+	}{
+		{route: "/message/servicebus/order-failed", topic: "order-failed", expectedStatus: 200},
+		{route: "/message/servicebus/order-failed", topic: "order", expectedStatus: 200},
+		{route: "/message/servicebus/payment-failed", topic: "payment", expectedStatus: 200},
+		{route: "/message/servicebus/payment-failed", topic: "order", expectedStatus: 400},
+		{route: "/message/servicebus/order", topic: "order-failed", expectedStatus: 400},
+	}
+
+	for _, tc := range testCases {
+		body := fmt.Sprintf(`{"specversion":"1.0","id":"1","pubsubname":"servicebus","topic":"%s","datacontenttype":"application/json","data":{}}`, tc.topic)
+		wrec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", tc.route, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+		svc.HttpHandler().ServeHTTP(wrec, req)
+		if want, got := tc.expectedStatus, wrec.Result().StatusCode; want != got {
+			t.Errorf("Expected status %d for topic '%s' at route '%s', got %d", want, tc.topic, tc.route, got)
+		}
+	}
+}
+
+func Test_DaprSubscribeBulk(t *testing.T) {
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterBulkMessageHandler("order", daprsvc.PubsubOptions{}, daprsvc.BulkSubscribeOptions{MaxMessagesCount: 100, MaxAwaitDurationMs: 40}, func(ctx context.Context, msgs []daprsvc.Message) map[string]daprsvc.MessageResult {
+		return nil
+	})
+
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/dapr/subscribe", nil)
+	svc.HttpHandler().ServeHTTP(wrec, req)
+	result := wrec.Result()
+
+	body, _ := io.ReadAll(result.Body)
+	expected := `[{
+		"pubsubname": "servicebus",
+		"topic": "order",
+		"route": "/message/servicebus/order",
+		"bulkSubscribe": {"enabled": true, "maxMessagesCount": 100, "maxAwaitDurationMs": 40},
+		"metadata": {}
+	}]`
+	if want, got := equalJson, IsEqualJson(expected, body); want != got {
+		t.Errorf("Expected body to equal '%s' got '%s'", expected, string(body))
+	}
+}
+
+func Test_DaprSubscribeBulkMessageHandler(t *testing.T) {
+	pubsubName := "servicebus"
+	testTopic := "test-topic"
+
+	svc := daprsvc.New()
+	ps := svc.NewPubsub(pubsubName)
+	ps.RegisterBulkMessageHandler(testTopic, daprsvc.PubsubOptions{}, daprsvc.BulkSubscribeOptions{}, func(ctx context.Context, msgs []daprsvc.Message) map[string]daprsvc.MessageResult {
+		results := make(map[string]daprsvc.MessageResult)
+		for _, msg := range msgs {
+			data := make(map[string]interface{})
+			if jsonErr := msg.Json(&data); jsonErr != nil {
+				results[msg.EntryId] = daprsvc.MessageResultDrop(jsonErr)
+			} else if retry, _ := data["RETRY"].(bool); retry {
+				results[msg.EntryId] = daprsvc.MessageResultRetry(errors.New("Something went wrong."))
+			} else if skip, _ := data["SKIP"].(bool); !skip {
+				results[msg.EntryId] = daprsvc.MessageResultSuccess()
+			}
+		}
+		return results
+	})
+
+	makeEntry := func(entryId string, data map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"entryId":     entryId,
+			"contentType": "application/cloudevents+json",
+			"event": map[string]interface{}{
+				"id":              "id-" + entryId,
+				"source":          "test-case",
+				"specversion":     "1.0",
+				"type":            "test-event",
+				"datacontenttype": "application/json",
+				"data":            data,
+				"pubsubname":      pubsubName,
+				"topic":           testTopic,
+			},
+		}
+	}
+
+	wrongTopicEntry := makeEntry("4", map[string]interface{}{})
+	wrongTopicEntry["event"].(map[string]interface{})["topic"] = "other-topic"
+	// NOTE: Content-type parameters don't prevent parsing the cloud-event.
+	charsetEntry := makeEntry("5", map[string]interface{}{"RETRY": true})
+	charsetEntry["contentType"] = "application/cloudevents+json; charset=utf-8"
+	charsetWrongTopicEntry := makeEntry("6", map[string]interface{}{})
+	charsetWrongTopicEntry["contentType"] = "application/cloudevents+json; charset=utf-8"
+	charsetWrongTopicEntry["event"].(map[string]interface{})["topic"] = "other-topic"
+
+	bulkMessage := map[string]interface{}{
+		"id":         "bulk-1",
+		"pubsubname": pubsubName,
+		"topic":      testTopic,
+		"type":       "com.dapr.event.sent.bulk",
+		"entries": []interface{}{
+			makeEntry("1", map[string]interface{}{"dummy": 123}),
+			makeEntry("2", map[string]interface{}{"RETRY": true}),
+			makeEntry("3", map[string]interface{}{"SKIP": true}),
+			wrongTopicEntry,
+			charsetEntry,
+			charsetWrongTopicEntry,
+		},
+	}
+
+	wrec := httptest.NewRecorder()
+	buf, _ := json.Marshal(bulkMessage)
+	req := httptest.NewRequest("POST", "/message/servicebus/test-topic", bytes.NewReader(buf))
+	req.Header.Add("Content-type", "application/json")
+	svc.HttpHandler().ServeHTTP(wrec, req)
+	result := wrec.Result()
+
+	if want, got := 200, result.StatusCode; want != got {
+		t.Errorf("Expected response status to be '%d' got '%d'", want, got)
+	}
+
+	response := struct {
+		Statuses []struct {
+			EntryId string `json:"entryId"`
+			Status  string `json:"status"`
+		} `json:"statuses"`
+	}{}
+	body, _ := io.ReadAll(result.Body)
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Failed to parse bulk response '%s': %s", string(body), err)
+	}
+
+	expectedStatuses := []string{"SUCCESS", "RETRY", "RETRY", "DROP", "RETRY", "DROP"}
+	if want, got := len(expectedStatuses), len(response.Statuses); want != got {
+		t.Fatalf("Expected %d statuses got %d", want, got)
+	}
+	for i, status := range response.Statuses {
+		if want, got := fmt.Sprint(i+1), status.EntryId; want != got {
+			t.Errorf("Status %d: Expected entry id '%s' got '%s'", i, want, got)
+		}
+		if want, got := expectedStatuses[i], status.Status; want != got {
+			t.Errorf("Status %d: Expected status '%s' got '%s'", i, want, got)
+		}
+	}
+}
+
+func Test_DaprSubscribeTypedHandler(t *testing.T) {
+	pubsubName := "servicebus"
+	testTopic := "test-topic"
+
+	type Order struct {
+		Id     string `json:"id" xml:"id"`
+		Amount int    `json:"amount" xml:"amount"`
+	}
+
+	svc := daprsvc.New()
+	ps := svc.NewPubsub(pubsubName)
+	received := Order{}
+	daprsvc.RegisterTypedHandler(ps, testTopic, daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message, order Order) daprsvc.MessageResult {
+		received = order
+		return daprsvc.MessageResultSuccess()
+	})
+
+	testCases := []struct {
+		contentType            string
+		data                   interface{}
+		dataBase64             string
+		expectedResponseStatus int
+		expectedOrder          Order
+	}{
+		{
+			contentType:            "application/json",
+			data:                   map[string]interface{}{"id": "order-1", "amount": 3},
+			expectedResponseStatus: 200,
+			expectedOrder:          Order{Id: "order-1", Amount: 3},
+		},
+		{
+			contentType:            "application/xml",
+			dataBase64:             "PE9yZGVyPjxpZD5vcmRlci0yPC9pZD48YW1vdW50PjU8L2Ftb3VudD48L09yZGVyPg==",
+			expectedResponseStatus: 200,
+			expectedOrder:          Order{Id: "order-2", Amount: 5},
+		},
+		{
+			contentType:            "application/json",
+			data:                   "not-an-order",
+			expectedResponseStatus: 400,
+		},
+		{
+			contentType:            "application/octet-stream",
+			dataBase64:             "AAEC",
+			expectedResponseStatus: 400,
+		},
+	}
+
+	for i, tc := range testCases {
+		received = Order{}
+		cloudEvent := map[string]interface{}{
+			"id":              "1234-5678",
+			"source":          "test-case",
+			"specversion":     "1.0",
+			"type":            "test-event",
+			"datacontenttype": tc.contentType,
+			"pubsubname":      pubsubName,
+			"topic":           testTopic,
+		}
+		if tc.dataBase64 != "" {
+			cloudEvent["data_base64"] = tc.dataBase64
+		} else {
+			cloudEvent["data"] = tc.data
+		}
+		buf, _ := json.Marshal(cloudEvent)
+		wrec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/message/servicebus/test-topic", bytes.NewReader(buf))
+		req.Header.Add("Content-type", "application/cloudevents+json")
+		svc.HttpHandler().ServeHTTP(wrec, req)
+		result := wrec.Result()
+
+		if want, got := tc.expectedResponseStatus, result.StatusCode; want != got {
+			body, _ := io.ReadAll(result.Body)
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d' (%s)", i, want, got, string(body))
+		}
+		if want, got := tc.expectedOrder, received; want != got {
+			t.Errorf("Test case %d: Expected decoded order %+v got %+v", i, want, got)
+		}
+	}
+}
+
+func Test_DaprSubscribeMessageMiddleware(t *testing.T) {
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+
+	calls := []string{}
+	makeMiddleware := func(name string) daprsvc.MessageMiddleware {
+		return func(next daprsvc.MessageHandler) daprsvc.MessageHandler {
+			return func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+				calls = append(calls, name+":before")
+				result := next(ctx, msg)
+				calls = append(calls, name+":after")
+				return result
+			}
+		}
+	}
+
+	svc.UseMessageMiddleware(makeMiddleware("svc1"), makeMiddleware("svc2"))
+	ps.UseMessageMiddleware(makeMiddleware("pubsub"))
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{NoCloudEvent: true, Middleware: []daprsvc.MessageMiddleware{makeMiddleware("entry")}}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		calls = append(calls, "handler")
+		return daprsvc.MessageResultSuccess()
+	})
+
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewBufferString("{}"))
+	svc.HttpHandler().ServeHTTP(wrec, req)
+
+	expected := []string{
+		"svc1:before", "svc2:before", "pubsub:before", "entry:before",
+		"handler",
+		"entry:after", "pubsub:after", "svc2:after", "svc1:after",
+	}
+	if want, got := expected, calls; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected middleware calls %v got %v", want, got)
+	}
+}
+
+func Test_DaprSubscribeBulkMessageMiddleware(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ps := svc.NewPubsub("servicebus")
+
+	var mu sync.Mutex
+	calls := map[string][]string{}
+	svc.UseMessageMiddleware(func(next daprsvc.MessageHandler) daprsvc.MessageHandler {
+		return func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+			mu.Lock()
+			calls[msg.EntryId] = append(calls[msg.EntryId], "svc:before")
+			mu.Unlock()
+			result := next(ctx, msg)
+			status := "RETRY"
+			if result.Success() {
+				status = "SUCCESS"
+			} else if result.Drop() {
+				status = "DROP"
+			}
+			mu.Lock()
+			calls[msg.EntryId] = append(calls[msg.EntryId], "svc:after:"+status)
+			mu.Unlock()
+			return result
+		}
+	})
+	ps.UseMessageMiddleware(func(next daprsvc.MessageHandler) daprsvc.MessageHandler {
+		return func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+			switch msg.Metadata["auth"] {
+			case "denied":
+				return daprsvc.MessageResultDrop(errors.New("Unauthorized."))
+			case "panic":
+				panic("boom")
+			}
+			msg.Metadata["checked"] = "true"
+			return next(ctx, msg)
+		}
+	})
+
+	var handled []string
+	ps.RegisterBulkMessageHandler("order", daprsvc.PubsubOptions{NoCloudEvent: true}, daprsvc.BulkSubscribeOptions{}, func(ctx context.Context, msgs []daprsvc.Message) map[string]daprsvc.MessageResult {
+		results := map[string]daprsvc.MessageResult{}
+		for _, msg := range msgs {
+			handled = append(handled, msg.EntryId+":"+msg.Metadata["checked"])
+			results[msg.EntryId] = daprsvc.MessageResultSuccess()
+		}
+		return results
+	})
+
+	body := `{"entries":[
+		{"entryId":"1","event":"e30=","contentType":"application/json","metadata":{"auth":"ok"}},
+		{"entryId":"2","event":"e30=","contentType":"application/json","metadata":{"auth":"denied"}},
+		{"entryId":"3","event":"e30=","contentType":"application/json","metadata":{"auth":"ok"}},
+		{"entryId":"4","event":"e30=","contentType":"application/json","metadata":{"auth":"panic"}}
+	]}`
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/message/servicebus/order", strings.NewReader(body))
+	svc.HttpHandler().ServeHTTP(wrec, req)
+
+	respBody, _ := io.ReadAll(wrec.Result().Body)
+	expectedBody := `{"statuses":[
+		{"entryId":"1","status":"SUCCESS"},
+		{"entryId":"2","status":"DROP","error":"Unauthorized."},
+		{"entryId":"3","status":"SUCCESS"},
+		{"entryId":"4","status":"RETRY","error":"Message handler panicked: boom"}
+	]}`
+	if want, got := equalJson, IsEqualJson(expectedBody, respBody); want != got {
+		t.Errorf("Expected body '%s' got '%s'", expectedBody, string(respBody))
+	}
+	if want, got := []string{"1:true", "3:true"}, handled; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected handled messages %v got %v", want, got)
+	}
+	expectedCalls := map[string][]string{
+		"1": {"svc:before", "svc:after:SUCCESS"},
+		"2": {"svc:before", "svc:after:DROP"},
+		"3": {"svc:before", "svc:after:SUCCESS"},
+		"4": {"svc:before"},
+	}
+	if want, got := expectedCalls, calls; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected middleware calls %v got %v", want, got)
+	}
+}
+
+func Test_DaprSubscribeMessageHandlerPanic(t *testing.T) {
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+
+	hookErrors := []error{}
+	svc.SetMessageErrorHook(func(ctx context.Context, msg daprsvc.Message, err error) {
+		hookErrors = append(hookErrors, err)
+	})
+
+	panicHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		panic("boom")
+	}
+	ps.RegisterMessageHandler("retry", daprsvc.PubsubOptions{NoCloudEvent: true}, panicHandler)
+	ps.RegisterMessageHandler("drop", daprsvc.PubsubOptions{NoCloudEvent: true, DropOnPanic: true}, panicHandler)
+
+	testCases := []struct {
+		path                   string
+		expectedResponseStatus int
+		expectedStatus         string
+	}{
+		{path: "/message/servicebus/retry", expectedResponseStatus: 500, expectedStatus: "RETRY"},
+		{path: "/message/servicebus/drop", expectedResponseStatus: 400, expectedStatus: "DROP"},
+	}
+
+	handler := svc.HttpHandler()
+	for i, tc := range testCases {
+		hookErrors = hookErrors[:0]
+		wrec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", tc.path, bytes.NewBufferString("{}"))
+		handler.ServeHTTP(wrec, req)
+		result := wrec.Result()
+
+		if want, got := tc.expectedResponseStatus, result.StatusCode; want != got {
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d'", i, want, got)
+		}
+
+		body, _ := io.ReadAll(result.Body)
+		expected := fmt.Sprintf(`{"status":"%s","error":"Message handler panicked: boom"}`, tc.expectedStatus)
+		if want, got := equalJson, IsEqualJson(expected, body); want != got {
+			t.Errorf("Test case %d: Expected body to equal '%s' got '%s'", i, expected, string(body))
+		}
+
+		if want, got := 1, len(hookErrors); want != got {
+			t.Fatalf("Test case %d: Expected error hook to be called %d times got %d", i, want, got)
+		}
+		var panicErr *daprsvc.PanicError
+		if !errors.As(hookErrors[0], &panicErr) {
+			t.Fatalf("Test case %d: Expected error hook to receive a panic error got %v", i, hookErrors[0])
+		}
+		if want, got := "boom", panicErr.Value; want != got {
+			t.Errorf("Test case %d: Expected panic value '%v' got '%v'", i, want, got)
+		}
+	}
+}
+
+func Test_Logger(t *testing.T) {
+	logBuf := &bytes.Buffer{}
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewJSONHandler(logBuf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{NoCloudEvent: true}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultDrop(errors.New("Client error."))
+	})
+	ps.RegisterMessageHandler("payment", daprsvc.PubsubOptions{NoCloudEvent: true}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	svc.SetInvocationHandler(mux)
+	handler := svc.HttpHandler()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewBufferString("{}")))
+	invocationReq := httptest.NewRequest("GET", "/hello", nil)
+	invocationReq.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	doInvocationRequest(handler, invocationReq)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/message/servicebus/payment", bytes.NewBufferString("{}")))
+	doInvocationRequest(handler, httptest.NewRequest("GET", "/ok", nil))
+
+	records := []map[string]interface{}{}
+	decoder := json.NewDecoder(logBuf)
+	for decoder.More() {
+		record := map[string]interface{}{}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Failed to decode log record: %s", err)
+		}
+		records = append(records, record)
+	}
+
+	if want, got := 4, len(records); want != got {
+		t.Fatalf("Expected %d log records got %d", want, got)
+	}
+
+	expectedMessageRecord := map[string]interface{}{
+		"level":  "WARN",
+		"msg":    "Message dropped",
+		"pubsub": "servicebus",
+		"topic":  "order",
+		"status": "DROP",
+		"error":  "Client error.",
+	}
+	for key, value := range expectedMessageRecord {
+		if want, got := value, records[0][key]; want != got {
+			t.Errorf("Expected message log record attribute '%s' to be '%v' got '%v'", key, want, got)
+		}
+	}
+	if _, present := records[0]["duration"]; !present {
+		t.Errorf("Expected message log record to include the duration")
+	}
+
+	expectedInvocationRecord := map[string]interface{}{
+		"level":       "INFO",
+		"msg":         "Invocation request handled",
+		"method":      "GET",
+		"path":        "/hello",
+		"callerAppId": "test",
+		"traceId":     "0af7651916cd43dd8448eb211c80319c",
+		"status":      float64(404),
+	}
+	for key, value := range expectedInvocationRecord {
+		if want, got := value, records[1][key]; want != got {
+			t.Errorf("Expected invocation log record attribute '%s' to be '%v' got '%v'", key, want, got)
+		}
+	}
+
+	// NOTE: Successful messages and invocation requests are logged at debug level.
+	for i, expectedMsg := range map[int]string{2: "Message handled", 3: "Invocation request handled"} {
+		if want, got := expectedMsg, records[i]["msg"]; want != got {
+			t.Errorf("Expected log record %d to be '%v' got '%v'", i, want, got)
+		}
+		if want, got := "DEBUG", records[i]["level"]; want != got {
+			t.Errorf("Expected log record %d to have level '%v' got '%v'", i, want, got)
+		}
+	}
+}
+
+func Test_Metrics(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc.SetMetricsRoute("/metrics")
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{NoCloudEvent: true}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	})
+	ps.RegisterMessageHandler("payment", daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	})
+	handler := svc.HttpHandler()
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewBufferString("{}")))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/message/servicebus/payment", bytes.NewBufferString("not-a-cloud-event")))
+	doInvocationRequest(handler, httptest.NewRequest("GET", "/hello", nil))
+
+	wrec := httptest.NewRecorder()
+	handler.ServeHTTP(wrec, httptest.NewRequest("GET", "/metrics", nil))
+	result := wrec.Result()
+
+	if want, got := 200, result.StatusCode; want != got {
+		t.Fatalf("Expected response status to be '%d' got '%d'", want, got)
+	}
+
+	body, _ := io.ReadAll(result.Body)
+	expectedLines := []string{
+		`# TYPE daprsvc_messages_received_total counter`,
+		`daprsvc_messages_received_total{pubsub="servicebus",topic="order"} 2`,
+		`daprsvc_messages_received_total{pubsub="servicebus",topic="payment"} 1`,
+		`daprsvc_messages_handled_total{pubsub="servicebus",topic="order",status="SUCCESS"} 2`,
+		`daprsvc_message_parse_failures_total{pubsub="servicebus",topic="payment"} 1`,
+		`# TYPE daprsvc_message_handler_duration_seconds histogram`,
+		`daprsvc_message_handler_duration_seconds_bucket{pubsub="servicebus",topic="order",status="SUCCESS",le="+Inf"} 2`,
+		`daprsvc_message_handler_duration_seconds_count{pubsub="servicebus",topic="order",status="SUCCESS"} 2`,
+		`daprsvc_invocation_requests_total{method="GET",route="*",code="404"} 1`,
+		`daprsvc_invocation_request_duration_seconds_count{method="GET",route="*"} 1`,
+	}
+	lines := strings.Split(string(body), "\n")
+	for _, expectedLine := range expectedLines {
+		if !slices.Contains(lines, expectedLine) {
+			t.Errorf("Expected metrics to contain line '%s' got:\n%s", expectedLine, string(body))
+		}
+	}
+}
+
+func Test_ParseTraceContext(t *testing.T) {
+	testCases := []struct {
+		traceparent   string
+		tracestate    string
+		expectedError bool
+		expected      daprsvc.TraceContext
+	}{
+		{
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			tracestate:  "congo=t61rcWkgMzE, rojo=00f067aa0ba902b7,invalid",
+			expected: daprsvc.TraceContext{
+				TraceId:  "4bf92f3577b34da6a3ce929d0e0e4736",
+				ParentId: "00f067aa0ba902b7",
+				Flags:    1,
+				State:    []daprsvc.TraceStateEntry{{Key: "congo", Value: "t61rcWkgMzE"}, {Key: "rojo", Value: "00f067aa0ba902b7"}},
+			},
+		},
+		{
+			traceparent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future",
+			expected: daprsvc.TraceContext{
+				TraceId:  "4bf92f3577b34da6a3ce929d0e0e4736",
+				ParentId: "00f067aa0ba902b7",
+			},
+		},
+		{traceparent: "", expectedError: true},
+		{traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", expectedError: true},
+		{traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", expectedError: true},
+		{traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", expectedError: true},
+		{traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", expectedError: true},
+		{traceparent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", expectedError: true},
+	}
+
+	for i, tc := range testCases {
+		result, err := daprsvc.ParseTraceContext(tc.traceparent, tc.tracestate)
+		if want, got := tc.expectedError, err != nil; want != got {
+			t.Errorf("Test case %d: Expected error %v got %v", i, want, got)
+			continue
+		}
+		if !tc.expectedError && !reflect.DeepEqual(tc.expected, result) {
+			t.Errorf("Test case %d: Expected trace context %+v got %+v", i, tc.expected, result)
+		}
+	}
+
+	parsed, _ := daprsvc.ParseTraceContext(testCases[0].traceparent, testCases[0].tracestate)
+	if want, got := testCases[0].traceparent, parsed.Traceparent(); want != got {
+		t.Errorf("Expected traceparent '%s' got '%s'", want, got)
+	}
+	if want, got := "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", parsed.Tracestate(); want != got {
+		t.Errorf("Expected tracestate '%s' got '%s'", want, got)
+	}
+
+	child := parsed.NewChild()
+	if want, got := parsed.TraceId, child.TraceId; want != got {
+		t.Errorf("Expected child trace id '%s' got '%s'", want, got)
+	}
+	if _, err := daprsvc.ParseTraceContext(child.Traceparent(), ""); err != nil || child.ParentId == parsed.ParentId {
+		t.Errorf("Expected child to have a new valid parent id, got '%s'", child.Traceparent())
+	}
+}
+
+func Test_TraceContextPropagation(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var messageTrace, invocationTrace daprsvc.TraceContext
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		messageTrace, _ = daprsvc.TraceFromContext(ctx)
+		return daprsvc.MessageResultSuccess()
+	})
+	svc.SetInvocationHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		invocationTrace, _ = daprsvc.TraceFromContext(r.Context())
+	}))
+	handler := svc.HttpHandler()
+
+	cloudEvent := map[string]interface{}{
+		"id":              "1234-5678",
+		"source":          "test-case",
+		"specversion":     "1.0",
+		"type":            "test-event",
+		"datacontenttype": "application/json",
+		"data":            map[string]interface{}{},
+		"pubsubname":      "servicebus",
+		"topic":           "order",
+		"traceparent":     traceparent,
+		"tracestate":      "congo=t61rcWkgMzE",
+	}
+	buf, _ := json.Marshal(cloudEvent)
+	req := httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewReader(buf))
+	req.Header.Add("Content-type", "application/cloudevents+json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if want, got := traceparent, messageTrace.Traceparent(); want != got {
+		t.Errorf("Expected message handler trace '%s' got '%s'", want, got)
+	}
+	if want, got := "congo=t61rcWkgMzE", messageTrace.Tracestate(); want != got {
+		t.Errorf("Expected message handler tracestate '%s' got '%s'", want, got)
+	}
+
+	req = httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Add("Traceparent", traceparent)
+	doInvocationRequest(handler, req)
+
+	if want, got := traceparent, invocationTrace.Traceparent(); want != got {
+		t.Errorf("Expected invocation handler trace '%s' got '%s'", want, got)
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	}
+
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{}, noopHandler)
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{Match: `event.type == "a"`}, noopHandler)
+	if err := svc.Validate(); err != nil {
+		t.Errorf("Expected no validation error got: %s", err)
+	}
+	if _, err := svc.BuildHttpHandler(); err != nil {
+		t.Errorf("Expected http handler to build got: %s", err)
+	}
+
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{}, noopHandler)
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{Match: `event.type == "a"`}, noopHandler)
+	ps.RegisterMessageHandler("", daprsvc.PubsubOptions{}, noopHandler)
+	ps.RegisterMessageHandler("payment", daprsvc.PubsubOptions{DeadLetterTopic: "payment"}, noopHandler)
+	svc.NewPubsub("other")
+	svc.NewPubsub("other")
+
+	err := svc.Validate()
+	if err == nil {
+		t.Fatalf("Expected validation errors")
+	}
+	expectedErrors := []string{
+		"Pubsub 'other' is created more than once, handlers registered before the last creation are dropped.",
+		"Pubsub 'servicebus': 2 handlers without match rule registered for topic 'order'.",
+		"Pubsub 'servicebus': 2 handlers registered for topic 'order' with match rule 'event.type == \"a\"'.",
+		"Pubsub 'servicebus': handler registered for empty topic.",
+		"Pubsub 'servicebus': topic 'payment' is its own dead-letter topic.",
+	}
+	errLines := strings.Split(err.Error(), "\n")
+	for _, expected := range expectedErrors {
+		if !slices.Contains(errLines, expected) {
+			t.Errorf("Expected validation error '%s' in:\n%s", expected, err)
+		}
+	}
+	if want, got := len(expectedErrors), len(errLines); want != got {
+		t.Errorf("Expected %d validation errors got %d:\n%s", want, got, err)
+	}
+
+	if handler, err := svc.BuildHttpHandler(); handler != nil || err == nil {
+		t.Errorf("Expected building the http handler to fail")
+	}
+}
+
+func Test_DaprSubscribeOrder(t *testing.T) {
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	}
+
+	svc := daprsvc.New()
+	pubsubNames := []string{"zeta", "alpha", "mu", "beta", "omega", "gamma"}
+	for _, name := range pubsubNames {
+		ps := svc.NewPubsub(name)
+		ps.RegisterMessageHandler("second", daprsvc.PubsubOptions{}, noopHandler)
+		ps.RegisterMessageHandler("first", daprsvc.PubsubOptions{}, noopHandler)
+	}
+
+	expected := []string{}
+	for _, name := range pubsubNames {
+		expected = append(expected, name+"/second", name+"/first")
+	}
+
+	for i := 0; i < 10; i++ {
+		wrec := httptest.NewRecorder()
+		svc.HttpHandler().ServeHTTP(wrec, httptest.NewRequest("GET", "/dapr/subscribe", nil))
+
+		subscriptions := []struct {
+			Pubsubname string `json:"pubsubname"`
+			Topic      string `json:"topic"`
+		}{}
+		body, _ := io.ReadAll(wrec.Result().Body)
+		if err := json.Unmarshal(body, &subscriptions); err != nil {
+			t.Fatalf("Failed to parse subscriptions '%s': %s", string(body), err)
+		}
+
+		got := []string{}
+		for _, sub := range subscriptions {
+			got = append(got, sub.Pubsubname+"/"+sub.Topic)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("Attempt %d: Expected subscriptions in registration order %v got %v", i, expected, got)
+		}
+	}
+}
+
+func Test_DaprSubscribeSpecialTopicNames(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ps := svc.NewPubsub("mqtt:broker")
+
+	topics := []string{"sensors/+/temp", "sensors/#", "a:b", "*", "..", "orders.created", "~7E", "~", "with space", "ünïcode"}
+	handled := ""
+	for _, topic := range topics {
+		topic := topic
+		ps.RegisterMessageHandler(topic, daprsvc.PubsubOptions{NoCloudEvent: true}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+			handled = topic
+			return daprsvc.MessageResultSuccess()
+		})
+	}
+	ps.RegisterMessageHandler("sensors/+/temp", daprsvc.PubsubOptions{NoCloudEvent: true, Match: `event.type == "x"`}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		handled = "rule"
+		return daprsvc.MessageResultSuccess()
+	})
+
+	handler, err := svc.BuildHttpHandler()
+	if err != nil {
+		t.Fatalf("Expected http handler to build got: %s", err)
+	}
+
+	wrec := httptest.NewRecorder()
+	handler.ServeHTTP(wrec, httptest.NewRequest("GET", "/dapr/subscribe", nil))
+	subscriptions := []struct {
+		Topic  string `json:"topic"`
+		Route  string `json:"route"`
+		Routes struct {
+			Rules []struct {
+				Path string `json:"path"`
+			} `json:"rules"`
+			Default string `json:"default"`
+		} `json:"routes"`
+	}{}
+	body, _ := io.ReadAll(wrec.Result().Body)
+	if err := json.Unmarshal(body, &subscriptions); err != nil {
+		t.Fatalf("Failed to parse subscriptions '%s': %s", string(body), err)
+	}
+
+	if want, got := "/message/mqtt~3Abroker/sensors~2F~2B~2Ftemp", subscriptions[0].Routes.Default; want != got {
+		t.Errorf("Expected escaped route '%s' got '%s'", want, got)
+	}
+
+	for _, sub := range subscriptions {
+		route := sub.Route
+		if route == "" {
+			route = sub.Routes.Default
+		}
+
+		handled = ""
+		wrec := httptest.NewRecorder()
+		handler.ServeHTTP(wrec, httptest.NewRequest("POST", route, bytes.NewBufferString("{}")))
+		if want, got := 200, wrec.Result().StatusCode; want != got {
+			t.Errorf("Topic '%s': Expected response status to be '%d' got '%d' for route '%s'", sub.Topic, want, got, route)
+		}
+		if want, got := sub.Topic, handled; want != got {
+			t.Errorf("Expected message on route '%s' to be handled for topic '%s' got '%s'", route, want, got)
+		}
+	}
+
+	handled = ""
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", subscriptions[0].Routes.Rules[0].Path, bytes.NewBufferString("{}")))
+	if want, got := "rule", handled; want != got {
+		t.Errorf("Expected message on rule route to be handled by '%s' got '%s'", want, got)
+	}
+}
+
+func Test_BasePathAndMessageRoutePrefix(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc.SetBasePath("/dapr-app/")
+	svc.SetMessageRoutePrefix("events")
+	svc.SetMetricsRoute("metrics/")
+	svc.SetFallbackHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{NoCloudEvent: true}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	})
+	handler := svc.HttpHandler()
+
+	wrec := httptest.NewRecorder()
+	handler.ServeHTTP(wrec, httptest.NewRequest("GET", "/dapr-app/dapr/subscribe", nil))
+	body, _ := io.ReadAll(wrec.Result().Body)
+	expected := `[{"pubsubname":"servicebus","topic":"order","route":"/dapr-app/events/servicebus/order","metadata":{}}]`
+	if want, got := equalJson, IsEqualJson(expected, body); want != got {
+		t.Errorf("Expected body to equal '%s' got '%s'", expected, string(body))
+	}
+
+	testCases := []struct {
+		method                 string
+		path                   string
+		expectedResponseStatus int
+	}{
+		{method: "POST", path: "/dapr-app/events/servicebus/order", expectedResponseStatus: 200},
+		{method: "GET", path: "/dapr-app/metrics", expectedResponseStatus: 200},
+		{method: "GET", path: "/dapr/subscribe", expectedResponseStatus: http.StatusTeapot},
+		{method: "POST", path: "/message/servicebus/order", expectedResponseStatus: http.StatusTeapot},
+		{method: "GET", path: "/my-own-endpoint", expectedResponseStatus: http.StatusTeapot},
+	}
+
+	for i, tc := range testCases {
+		wrec := httptest.NewRecorder()
+		handler.ServeHTTP(wrec, httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString("{}")))
+		if want, got := tc.expectedResponseStatus, wrec.Result().StatusCode; want != got {
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d'", i, want, got)
+		}
+	}
+}
+
+func Test_DaprSubscribeMetadata(t *testing.T) {
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	}
+
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("kafka")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{
+		Metadata: map[string]string{"maxConcurrentHandlers": "4", "consumerID": "orders", "consumeRetryInterval": "200ms"},
+	}, noopHandler)
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{
+		Match:    `event.type == "a"`,
+		Metadata: map[string]string{"consumerID": "ignored", "queueName": "order-a"},
+	}, noopHandler)
+	ps.RegisterMessageHandler("payment", daprsvc.PubsubOptions{RawPayload: true, Metadata: map[string]string{"rawPayload": "false", "b": "2", "a": "1"}}, noopHandler)
+	ps.RegisterMessageHandler("refund", daprsvc.PubsubOptions{Metadata: map[string]string{"rawPayload": "true"}}, noopHandler)
+
+	wrec := httptest.NewRecorder()
+	svc.HttpHandler().ServeHTTP(wrec, httptest.NewRequest("GET", "/dapr/subscribe", nil))
+	body, _ := io.ReadAll(wrec.Result().Body)
+
+	expectedMetadata := []string{
+		`"metadata":{"consumeRetryInterval":"200ms","consumerID":"orders","maxConcurrentHandlers":"4","queueName":"order-a"}`,
+		`"metadata":{"a":"1","b":"2","rawPayload":"true"}`,
+		`"metadata":{"rawPayload":"true"}`,
+	}
+	for _, expected := range expectedMetadata {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected body to contain '%s' got '%s'", expected, string(body))
+		}
+	}
+}
+
+func Test_SubscriptionManifests(t *testing.T) {
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	}
+
+	svc := daprsvc.New()
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{DeadLetterTopic: "order-failed", Metadata: map[string]string{"queueName": "orders"}}, noopHandler)
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{Match: `event.type == "created"`}, noopHandler)
+	ps.RegisterBulkMessageHandler("Payment_Events", daprsvc.PubsubOptions{}, daprsvc.BulkSubscribeOptions{MaxMessagesCount: 10}, func(ctx context.Context, msgs []daprsvc.Message) map[string]daprsvc.MessageResult {
+		return nil
+	})
+
+	// The subscriptions exposed to the dapr daemon decode into the same subscriptions.
+	wrec := httptest.NewRecorder()
+	svc.HttpHandler().ServeHTTP(wrec, httptest.NewRequest("GET", "/dapr/subscribe", nil))
+	decoded := []daprsvc.Subscription{}
+	if err := json.NewDecoder(wrec.Result().Body).Decode(&decoded); err != nil {
+		t.Fatalf("Failed to decode subscriptions: %s", err)
+	}
+	if want, got := svc.Subscriptions(), decoded; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected decoded subscriptions %+v got %+v", want, got)
+	}
+
+	buf := &bytes.Buffer{}
+	err := svc.WriteSubscriptionManifests(buf, daprsvc.ManifestOptions{NamePrefix: "order-service", Namespace: "shop", Scopes: []string{"order-service"}})
+	if err != nil {
+		t.Fatalf("Failed to write manifests: %s", err)
+	}
+
+	expected := `apiVersion: dapr.io/v2alpha1
+kind: Subscription
+metadata:
+  name: order-service-servicebus-order
+  namespace: "shop"
+spec:
+  pubsubname: "servicebus"
+  topic: "order"
+  routes:
+    rules:
+      - match: "event.type == \"created\""
+        path: "/message/servicebus/order/rule/1"
+    default: "/message/servicebus/order"
+  deadLetterTopic: "order-failed"
+  metadata:
+    "queueName": "orders"
+scopes:
+  - "order-service"
+---
+apiVersion: dapr.io/v2alpha1
+kind: Subscription
+metadata:
+  name: order-service-servicebus-payment-events
+  namespace: "shop"
+spec:
+  pubsubname: "servicebus"
+  topic: "Payment_Events"
+  routes:
+    default: "/message/servicebus/Payment_Events"
+  bulkSubscribe:
+    enabled: true
+    maxMessagesCount: 10
+scopes:
+  - "order-service"
+`
+	if want, got := expected, buf.String(); want != got {
+		t.Errorf("Expected manifests:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func Test_CloudEventCodec(t *testing.T) {
+	envelope := `{
+		"specversion": "1.0",
+		"id": "1234-5678",
+		"source": "test-case",
+		"type": "test-event",
+		"datacontenttype": "application/json",
+		"subject": "order-1",
+		"time": "2024-02-13T11:30:06Z",
+		"data": {"amount": 3},
+		"tenantid": "tenant-a",
+		"partitionkey": 42,
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	}`
+
+	var ce daprsvc.CloudEvent
+	if err := json.Unmarshal([]byte(envelope), &ce); err != nil {
+		t.Fatalf("Failed to unmarshal cloud-event: %s", err)
+	}
+
+	if want, got := "1.0", ce.SpecVersion; want != got {
+		t.Errorf("Expected spec version '%s' got '%s'", want, got)
+	}
+	if want, got := time.Date(2024, 2, 13, 11, 30, 6, 0, time.UTC), ce.Time; !want.Equal(got) {
+		t.Errorf("Expected time '%s' got '%s'", want, got)
+	}
+	if want, got := `{"amount": 3}`, string(ce.Data); want != got {
+		t.Errorf("Expected data '%s' got '%s'", want, got)
+	}
+	expectedExtensions := map[string]any{
+		"tenantid":     "tenant-a",
+		"partitionkey": json.Number("42"),
+		"traceparent":  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	if want, got := expectedExtensions, ce.Extensions; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected extensions %v got %v", want, got)
+	}
+
+	marshalled, err := json.Marshal(ce)
+	if err != nil {
+		t.Fatalf("Failed to marshal cloud-event: %s", err)
+	}
+	if want, got := equalJson, IsEqualJson(envelope, marshalled); want != got {
+		t.Errorf("Expected marshalled cloud-event to equal '%s' got '%s'", envelope, string(marshalled))
+	}
+
+	if err := json.Unmarshal([]byte(`{"specversion": 1}`), &ce); err == nil {
+		t.Errorf("Expected error for non-string spec version")
+	}
+}
+
+func Test_DaprSubscribeMessageExtensions(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ps := svc.NewPubsub("servicebus")
+	var received daprsvc.Message
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		received = msg
+		return daprsvc.MessageResultSuccess()
+	})
+
+	cloudEvent := map[string]interface{}{
+		"id":              "1234-5678",
+		"source":          "test-case",
+		"specversion":     "1.0",
+		"type":            "test-event",
+		"datacontenttype": "application/json",
+		"data":            map[string]interface{}{},
+		"pubsubname":      "servicebus",
+		"topic":           "order",
+		"tenantid":        "tenant-a",
+	}
+	buf, _ := json.Marshal(cloudEvent)
+	req := httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewReader(buf))
+	req.Header.Add("Content-type", "application/cloudevents+json")
+	svc.HttpHandler().ServeHTTP(httptest.NewRecorder(), req)
+
+	if want, got := "1.0", received.Fields.SpecVersion; want != got {
+		t.Errorf("Expected spec version '%s' got '%s'", want, got)
+	}
+	if want, got := "tenant-a", received.Extensions["tenantid"]; want != got {
+		t.Errorf("Expected extension 'tenantid' to be '%v' got '%v'", want, got)
+	}
+	if want, got := "servicebus", received.Extensions["pubsubname"]; want != got {
+		t.Errorf("Expected extension 'pubsubname' to be '%v' got '%v'", want, got)
+	}
+	if want, got := string(buf), string(received.RawEnvelope); want != got {
+		t.Errorf("Expected raw envelope '%s' got '%s'", want, got)
+	}
+}
+
+func Test_DaprSubscribeBinaryCloudEvent(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ps := svc.NewPubsub("servicebus")
+	var received daprsvc.Message
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		received = msg
+		return daprsvc.MessageResultSuccess()
+	})
+	handler := svc.HttpHandler()
+
+	testCases := []struct {
+		contentType     string
+		body            string
+		withDestination bool // NOTE: Only the dapr daemon adds the pubsubname and topic attributes.
+		expectedData    string
+	}{
+		{contentType: "application/json", body: `{"amount":3}`, withDestination: true, expectedData: `{"amount":3}`},
+		{contentType: "text/plain", body: "Hello world", withDestination: true, expectedData: "Hello world"},
+		{contentType: "application/json", body: `{"amount":4}`, withDestination: false, expectedData: `{"amount":4}`},
+	}
+
+	for i, tc := range testCases {
+		received = daprsvc.Message{}
+		req := httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewBufferString(tc.body))
+		req.Header.Add("Content-Type", tc.contentType)
+		req.Header.Add("ce-specversion", "1.0")
+		req.Header.Add("ce-id", "1234-5678")
+		req.Header.Add("ce-source", "test-case")
+		req.Header.Add("ce-type", "test-event")
+		req.Header.Add("ce-subject", "order%201")
+		req.Header.Add("ce-time", "2024-02-13T11:30:06Z")
+		if tc.withDestination {
+			req.Header.Add("ce-pubsubname", "servicebus")
+			req.Header.Add("ce-topic", "order")
+		}
+		req.Header.Add("ce-tenantid", "tenant-a")
+		wrec := httptest.NewRecorder()
+		handler.ServeHTTP(wrec, req)
+
+		if want, got := 200, wrec.Result().StatusCode; want != got {
+			body, _ := io.ReadAll(wrec.Result().Body)
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d' (%s)", i, want, got, string(body))
+			continue
+		}
+
+		expectedFields := daprsvc.MessageFields{
+			SpecVersion: "1.0",
+			Source:      "test-case",
+			Type:        "test-event",
+			Subject:     "order 1",
+			Timestamp:   time.Date(2024, 2, 13, 11, 30, 6, 0, time.UTC),
+		}
+		if want, got := expectedFields, received.Fields; !reflect.DeepEqual(want, got) {
+			t.Errorf("Test case %d: Expected fields %+v got %+v", i, want, got)
+		}
+		if want, got := "1234-5678", received.Id; want != got {
+			t.Errorf("Test case %d: Expected message id '%s' got '%s'", i, want, got)
+		}
+		if want, got := tc.contentType, received.ContentType; want != got {
+			t.Errorf("Test case %d: Expected content type '%s' got '%s'", i, want, got)
+		}
+		if want, got := tc.expectedData, string(received.Data); want != got {
+			t.Errorf("Test case %d: Expected data '%s' got '%s'", i, want, got)
+		}
+		if want, got := "tenant-a", received.Extensions["tenantid"]; want != got {
+			t.Errorf("Test case %d: Expected extension 'tenantid' to be '%v' got '%v'", i, want, got)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewBufferString("{}"))
+	req.Header.Add("Content-Type", "application/json")
+	wrec := httptest.NewRecorder()
+	handler.ServeHTTP(wrec, req)
+	if want, got := 400, wrec.Result().StatusCode; want != got {
+		t.Errorf("Expected response status for message without cloud-event to be '%d' got '%d'", want, got)
+	}
+
+	req = httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewBufferString("{}"))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("ce-specversion", "1.0")
+	req.Header.Add("ce-id", "1234-5678")
+	req.Header.Add("ce-pubsubname", "servicebus")
+	req.Header.Add("ce-topic", "payment")
+	wrec = httptest.NewRecorder()
+	handler.ServeHTTP(wrec, req)
+	if want, got := 400, wrec.Result().StatusCode; want != got {
+		t.Errorf("Expected response status for message with wrong destination to be '%d' got '%d'", want, got)
+	}
+}
+
+func Test_DaprSubscribeCloudEventData(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ps := svc.NewPubsub("servicebus")
+	var received daprsvc.Message
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		received = msg
+		return daprsvc.MessageResultSuccess()
+	})
+	handler := svc.HttpHandler()
+
+	const absent = "<absent>"
+	testCases := []struct {
+		contentType            string
+		data                   string // NOTE: Raw json value of the data attribute.
+		dataBase64             string
+		expectedResponseStatus int
+		expectedData           string
+	}{
+		{contentType: absent, data: `{"a":1}`, dataBase64: absent, expectedResponseStatus: 200, expectedData: `{"a":1}`},
+		{contentType: "application/json", data: `{"a":1}`, dataBase64: absent, expectedResponseStatus: 200, expectedData: `{"a":1}`},
+		{contentType: "application/json; charset=utf-8", data: `[1,2]`, dataBase64: absent, expectedResponseStatus: 200, expectedData: `[1,2]`},
+		{contentType: "application/vnd.order+json", data: `"text"`, dataBase64: absent, expectedResponseStatus: 200, expectedData: `"text"`},
+		{contentType: "application/json", data: absent, dataBase64: absent, expectedResponseStatus: 200, expectedData: ``},
+		{contentType: "application/json", data: `null`, dataBase64: absent, expectedResponseStatus: 200, expectedData: ``},
+		{contentType: "application/json", data: absent, dataBase64: "eyJhIjoxfQ==", expectedResponseStatus: 200, expectedData: `{"a":1}`},
+		{contentType: "text/plain", data: `"Hello world"`, dataBase64: absent, expectedResponseStatus: 200, expectedData: `Hello world`},
+		{contentType: "text/plain; charset=utf-8", data: `"line 1\nline 2"`, dataBase64: absent, expectedResponseStatus: 200, expectedData: "line 1\nline 2"},
+		{contentType: "application/xml", data: `"<order id=\"1\"/>"`, dataBase64: absent, expectedResponseStatus: 200, expectedData: `<order id="1"/>`},
+		{contentType: "text/plain", data: `42`, dataBase64: absent, expectedResponseStatus: 200, expectedData: `42`},
+		{contentType: "text/plain", data: absent, dataBase64: "SGVsbG8=", expectedResponseStatus: 200, expectedData: `Hello`},
+		{contentType: "application/octet-stream", data: absent, dataBase64: "", expectedResponseStatus: 200, expectedData: ``},
+		{contentType: "application/octet-stream", data: absent, dataBase64: absent, expectedResponseStatus: 200, expectedData: ``},
+		{contentType: "application/octet-stream", data: absent, dataBase64: "not base64!", expectedResponseStatus: 400},
+		{contentType: "text/plain", data: `"Hello"`, dataBase64: "SGVsbG8=", expectedResponseStatus: 400},
+	}
+
+	for i, tc := range testCases {
+		received = daprsvc.Message{}
+		envelope := map[string]json.RawMessage{
+			"id":          json.RawMessage(`"1234-5678"`),
+			"source":      json.RawMessage(`"test-case"`),
+			"specversion": json.RawMessage(`"1.0"`),
+			"type":        json.RawMessage(`"test-event"`),
+			"pubsubname":  json.RawMessage(`"servicebus"`),
+			"topic":       json.RawMessage(`"order"`),
+		}
+		if tc.contentType != absent {
+			envelope["datacontenttype"], _ = json.Marshal(tc.contentType)
+		}
+		if tc.data != absent {
+			envelope["data"] = json.RawMessage(tc.data)
+		}
+		if tc.dataBase64 != absent {
+			envelope["data_base64"], _ = json.Marshal(tc.dataBase64)
+		}
+		buf, _ := json.Marshal(envelope)
+		req := httptest.NewRequest("POST", "/message/servicebus/order", bytes.NewReader(buf))
+		req.Header.Add("Content-type", "application/cloudevents+json")
+		wrec := httptest.NewRecorder()
+		handler.ServeHTTP(wrec, req)
+
+		if want, got := tc.expectedResponseStatus, wrec.Result().StatusCode; want != got {
+			body, _ := io.ReadAll(wrec.Result().Body)
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d' (%s)", i, want, got, string(body))
+			continue
+		}
+		if want, got := tc.expectedData, string(received.Data); tc.expectedResponseStatus == 200 && want != got {
+			t.Errorf("Test case %d: Expected data '%s' got '%s'", i, want, got)
+		}
+	}
+}
+
+func Test_DaprSubscribeMaxBodySize(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc.SetMaxMessageBodySize(16)
+	svc.SetMetricsRoute("/metrics")
+	ps := svc.NewPubsub("servicebus")
+	handled := 0
+	noopHandler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		handled++
+		return daprsvc.MessageResultSuccess()
+	}
+	ps.RegisterMessageHandler("small", daprsvc.PubsubOptions{NoCloudEvent: true}, noopHandler)
+	ps.RegisterMessageHandler("large", daprsvc.PubsubOptions{NoCloudEvent: true, MaxBodySize: 64}, noopHandler)
+	handler := svc.HttpHandler()
+
+	largeBody := `{"data":"` + strings.Repeat("x", 32) + `"}`
+	testCases := []struct {
+		path                   string
+		body                   string
+		expectedResponseStatus int
+		expectedResponseBody   string
+	}{
+		{path: "/message/servicebus/small", body: `{"a":1}`, expectedResponseStatus: 200, expectedResponseBody: `{"status":"SUCCESS"}`},
+		{path: "/message/servicebus/small", body: largeBody, expectedResponseStatus: 400, expectedResponseBody: `{"status":"DROP","error":"Message body exceeds maximum size of 16 bytes."}`},
+		{path: "/message/servicebus/large", body: largeBody, expectedResponseStatus: 200, expectedResponseBody: `{"status":"SUCCESS"}`},
+		{path: "/message/servicebus/large", body: largeBody + largeBody, expectedResponseStatus: 400, expectedResponseBody: `{"status":"DROP","error":"Message body exceeds maximum size of 64 bytes."}`},
+	}
+
+	for i, tc := range testCases {
+		wrec := httptest.NewRecorder()
+		handler.ServeHTTP(wrec, httptest.NewRequest("POST", tc.path, bytes.NewBufferString(tc.body)))
+		result := wrec.Result()
+
+		if want, got := tc.expectedResponseStatus, result.StatusCode; want != got {
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d'", i, want, got)
+		}
+		body, _ := io.ReadAll(result.Body)
+		if want, got := equalJson, IsEqualJson(tc.expectedResponseBody, body); want != got {
+			t.Errorf("Test case %d: Expected body to equal '%s' got '%s'", i, tc.expectedResponseBody, string(body))
+		}
+	}
+
+	if want, got := 2, handled; want != got {
+		t.Errorf("Expected %d messages to be handled got %d", want, got)
+	}
+
+	wrec := httptest.NewRecorder()
+	handler.ServeHTTP(wrec, httptest.NewRequest("GET", "/metrics", nil))
+	metricsBody, _ := io.ReadAll(wrec.Result().Body)
+	expectedLine := `daprsvc_messages_rejected_total{pubsub="servicebus",topic="small",reason="body_too_large"} 1`
+	if !slices.Contains(strings.Split(string(metricsBody), "\n"), expectedLine) {
+		t.Errorf("Expected metrics to contain line '%s' got:\n%s", expectedLine, string(metricsBody))
+	}
+}
+
+func Test_MessageDecodeCodecs(t *testing.T) {
+	var obj struct {
+		Value int `json:"value" xml:"value"`
+	}
+	if err := (daprsvc.Message{ContentType: "application/vnd.order+json", Data: []byte(`{"value":3}`)}).Decode(&obj); err != nil || obj.Value != 3 {
+		t.Errorf("Expected json suffix decoding to result in 3 got %d (error: %v)", obj.Value, err)
+	}
+	if err := (daprsvc.Message{ContentType: "text/xml; charset=utf-8", Data: []byte(`<obj><value>5</value></obj>`)}).Decode(&obj); err != nil || obj.Value != 5 {
+		t.Errorf("Expected xml decoding to result in 5 got %d (error: %v)", obj.Value, err)
+	}
+
+	var text string
+	if err := (daprsvc.Message{ContentType: "text/csv", Data: []byte("a,b")}).Decode(&text); err != nil || text != "a,b" {
+		t.Errorf("Expected text decoding to result in 'a,b' got '%s' (error: %v)", text, err)
+	}
+
+	var raw []byte
+	if err := (daprsvc.Message{ContentType: "image/png", Data: []byte{1, 2}}).Decode(&raw); err != nil || !bytes.Equal(raw, []byte{1, 2}) {
+		t.Errorf("Expected raw data got %v (error: %v)", raw, err)
+	}
+
+	err := (daprsvc.Message{ContentType: "image/png", Data: []byte{1, 2}}).Decode(&obj)
+	var unknownErr *daprsvc.UnknownContentTypeError
+	if !errors.As(err, &unknownErr) || unknownErr.ContentType != "image/png" {
+		t.Errorf("Expected unknown content-type error for 'image/png' got %v", err)
+	}
+}
+
+func Test_DaprSubscribeServiceCodecs(t *testing.T) {
+	csvCodec := daprsvc.CodecFunc(func(data []byte, v any) error {
+		target, ok := v.(*[]string)
+		if !ok {
+			return fmt.Errorf("Can't decode csv into %T.", v)
+		}
+		*target = strings.Split(string(data), ",")
+		return nil
+	})
+
+	// NOTE: Codecs are registered per service, so only the service with the codec decodes csv.
+	svcWithCodec := daprsvc.New()
+	svcWithCodec.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svcWithCodec.RegisterCodec("Application/X-CSV", csvCodec)
+	// NOTE: A service codec overrides the builtin codec for the media type.
+	svcWithCodec.RegisterCodec("text/*", csvCodec)
+	var withCodecRows [][]string
+	daprsvc.RegisterTypedHandler(svcWithCodec.NewPubsub("servicebus"), "rows", daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message, row []string) daprsvc.MessageResult {
+		withCodecRows = append(withCodecRows, row)
+		return daprsvc.MessageResultSuccess()
+	})
+	svcWithoutCodec := daprsvc.New()
+	svcWithoutCodec.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var withoutCodecRows [][]string
+	daprsvc.RegisterTypedHandler(svcWithoutCodec.NewPubsub("servicebus"), "rows", daprsvc.PubsubOptions{}, func(ctx context.Context, msg daprsvc.Message, row []string) daprsvc.MessageResult {
+		withoutCodecRows = append(withoutCodecRows, row)
+		return daprsvc.MessageResultSuccess()
+	})
+
+	sendRow := func(handler http.Handler, contentType string, data string) int {
+		cloudEvent := map[string]interface{}{
+			"id":              "1234-5678",
+			"source":          "test-case",
+			"specversion":     "1.0",
+			"type":            "test-event",
+			"datacontenttype": contentType,
+			"data":            data,
+			"pubsubname":      "servicebus",
+			"topic":           "rows",
+		}
+		buf, _ := json.Marshal(cloudEvent)
+		req := httptest.NewRequest("POST", "/message/servicebus/rows", bytes.NewReader(buf))
+		req.Header.Add("Content-type", "application/cloudevents+json")
+		wrec := httptest.NewRecorder()
+		handler.ServeHTTP(wrec, req)
+		return wrec.Result().StatusCode
+	}
+
+	withCodecHandler := svcWithCodec.HttpHandler()
+	if want, got := 200, sendRow(withCodecHandler, "application/x-csv", "a,b"); want != got {
+		t.Errorf("Expected response status with codec to be '%d' got '%d'", want, got)
+	}
+	if want, got := 200, sendRow(withCodecHandler, "text/plain", "c,d"); want != got {
+		t.Errorf("Expected response status with overriding codec to be '%d' got '%d'", want, got)
+	}
+	if want, got := [][]string{{"a", "b"}, {"c", "d"}}, withCodecRows; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected rows %v got %v", want, got)
+	}
+
+	if want, got := 400, sendRow(svcWithoutCodec.HttpHandler(), "application/x-csv", "a,b"); want != got {
+		t.Errorf("Expected response status without codec to be '%d' got '%d'", want, got)
+	}
+	if want, got := 0, len(withoutCodecRows); want != got {
+		t.Errorf("Expected no rows without codec got %d", got)
+	}
+}
+
+func Test_DaprSubscribeSchemaValidation(t *testing.T) {
+	schemaDir := t.TempDir()
+	os.MkdirAll(filepath.Join(schemaDir, "common"), 0o755)
+	os.WriteFile(filepath.Join(schemaDir, "common", "types.json"), []byte(`{
+		"$defs": {"amount": {"type": "number", "exclusiveMinimum": 0}}
+	}`), 0o644)
+	os.WriteFile(filepath.Join(schemaDir, "order.json"), []byte(`{
+		"$id": "https://example.com/schemas/order.json",
+		"type": "object",
+		"required": ["id", "amount"],
+		"properties": {
+			"id": {"type": "string", "pattern": "^o-[0-9]+$"},
+			"amount": {"$ref": "common/types.json#/$defs/amount"},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true}
+		},
+		"additionalProperties": false
+	}`), 0o644)
+
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := svc.LoadSchemas(schemaDir); err != nil {
+		t.Fatalf("Failed to load schemas: %s", err)
+	}
+	if err := svc.RegisterSchema("urn:status", []byte(`{"enum": ["open", "closed"]}`)); err != nil {
+		t.Fatalf("Failed to register schema: %s", err)
+	}
+	if err := svc.RegisterSchema("urn:invalid", []byte(`[]`)); err == nil {
+		t.Errorf("Expected registering a non-object schema to fail")
+	}
+
+	handled := 0
+	handler := func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		handled++
+		return daprsvc.MessageResultSuccess()
+	}
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{Schema: "https://example.com/schemas/order.json"}, handler)
+	ps.RegisterMessageHandler("status", daprsvc.PubsubOptions{}, handler)
+
+	testCases := []struct {
+		topic                  string
+		dataSchema             string
+		data                   string
+		expectedResponseStatus int
+		expectedError          string
+	}{
+		{topic: "order", data: `{"id":"o-1","amount":2.5,"tags":["a","b"]}`, expectedResponseStatus: 200},
+		{topic: "order", data: `{"id":"x-1","amount":0,"tags":["a","a"],"note":"hi"}`, expectedResponseStatus: 400,
+			expectedError: "Message data does not match schema 'https://example.com/schemas/order.json': " +
+				"'/amount': value 0 is not greater than exclusive minimum 0; '/id': string does not match pattern '^o-[0-9]+$'; " +
+				"'/note': additional property is not allowed; '/tags': items 0 and 1 are equal"},
+		{topic: "order", data: `{"amount":"1"}`, expectedResponseStatus: 400,
+			expectedError: "Message data does not match schema 'https://example.com/schemas/order.json': " +
+				"'/': missing required property 'id'; '/amount': expected number, got string"},
+		{topic: "status", dataSchema: "urn:status", data: `"open"`, expectedResponseStatus: 200},
+		{topic: "status", dataSchema: "urn:status", data: `"lost"`, expectedResponseStatus: 400,
+			expectedError: "Message data does not match schema 'urn:status': '/': value is not one of the allowed values"},
+		{topic: "status", dataSchema: "urn:unregistered", data: `"lost"`, expectedResponseStatus: 200},
+		{topic: "status", dataSchema: "order.json", data: `{"id":"o-2","amount":1}`, expectedResponseStatus: 200},
+		{topic: "status", dataSchema: "common/types.json#/$defs/amount", data: `-1`, expectedResponseStatus: 400,
+			expectedError: "Message data does not match schema 'common/types.json#/$defs/amount': '/': value -1 is not greater than exclusive minimum 0"},
+	}
+
+	httpHandler := svc.HttpHandler()
+	for i, tc := range testCases {
+		cloudEvent := map[string]interface{}{
+			"id":              "1234-5678",
+			"source":          "test-case",
+			"specversion":     "1.0",
+			"type":            "test-event",
+			"datacontenttype": "application/json",
+			"data":            json.RawMessage(tc.data),
+			"pubsubname":      "servicebus",
+			"topic":           tc.topic,
+		}
+		if tc.dataSchema != "" {
+			cloudEvent["dataschema"] = tc.dataSchema
+		}
+		buf, _ := json.Marshal(cloudEvent)
+		req := httptest.NewRequest("POST", "/message/servicebus/"+tc.topic, bytes.NewReader(buf))
+		req.Header.Add("Content-type", "application/cloudevents+json")
+		wrec := httptest.NewRecorder()
+		httpHandler.ServeHTTP(wrec, req)
+		result := wrec.Result()
+
+		if want, got := tc.expectedResponseStatus, result.StatusCode; want != got {
+			t.Errorf("Test case %d: Expected response status to be '%d' got '%d'", i, want, got)
+		}
+		responseBody := struct {
+			Error string `json:"error"`
+		}{}
+		json.NewDecoder(result.Body).Decode(&responseBody)
+		if want, got := tc.expectedError, responseBody.Error; want != got {
+			t.Errorf("Test case %d: Expected error '%s' got '%s'", i, want, got)
+		}
+	}
+
+	if want, got := 4, handled; want != got {
+		t.Errorf("Expected %d messages to be handled got %d", want, got)
+	}
+
+	ps.RegisterMessageHandler("unknown", daprsvc.PubsubOptions{Schema: "urn:unknown"}, handler)
+	expectedErr := "Pubsub 'servicebus': schema 'urn:unknown' for topic 'unknown' is not registered."
+	if err := svc.Validate(); err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected validation error '%s' got '%v'", expectedErr, err)
+	}
+}
+
+func Test_LoadSchemasWithoutIds(t *testing.T) {
+	schemaDir := t.TempDir()
+	os.MkdirAll(filepath.Join(schemaDir, "common"), 0o755)
+	os.MkdirAll(filepath.Join(schemaDir, "orders"), 0o755)
+	os.WriteFile(filepath.Join(schemaDir, "common", "types.json"), []byte(`{
+		"$defs": {"amount": {"type": "number", "exclusiveMinimum": 0}}
+	}`), 0o644)
+	os.WriteFile(filepath.Join(schemaDir, "orders", "order.json"), []byte(`{
+		"type": "object",
+		"properties": {"amount": {"$ref": "../common/types.json#/$defs/amount"}}
+	}`), 0o644)
+
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := svc.LoadSchemas(schemaDir); err != nil {
+		t.Fatalf("Failed to load schemas: %s", err)
+	}
+	ps := svc.NewPubsub("servicebus")
+	ps.RegisterMessageHandler("order", daprsvc.PubsubOptions{Schema: "orders/order.json"}, func(ctx context.Context, msg daprsvc.Message) daprsvc.MessageResult {
+		return daprsvc.MessageResultSuccess()
+	})
+	if err := svc.Validate(); err != nil {
+		t.Errorf("Expected no validation error got '%s'", err)
+	}
+
+	for data, expectedStatus := range map[string]int{`{"amount":1}`: 200, `{"amount":-1}`: 400} {
+		body := `{"specversion":"1.0","id":"1","pubsubname":"servicebus","topic":"order","datacontenttype":"application/json","data":` + data + `}`
+		req := httptest.NewRequest("POST", "/message/servicebus/order", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+		wrec := httptest.NewRecorder()
+		svc.HttpHandler().ServeHTTP(wrec, req)
+		if want, got := expectedStatus, wrec.Result().StatusCode; want != got {
+			respBody, _ := io.ReadAll(wrec.Result().Body)
+			t.Errorf("Expected status %d for data %s got %d: %s", want, data, got, respBody)
+		}
+	}
+}
+
+type sidecarRequest struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// Starts a stand-in for the dapr daemon that records requests and responds with the status and body.
+func newTestSidecar(t *testing.T, status int, responseBody string) (*httptest.Server, *[]sidecarRequest) {
+	requests := &[]sidecarRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*requests = append(*requests, sidecarRequest{method: r.Method, path: r.URL.EscapedPath(), query: r.URL.Query(), header: r.Header, body: body})
+		w.WriteHeader(status)
+		w.Write([]byte(responseBody))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func Test_SidecarOptionsFromEnv(t *testing.T) {
+	t.Setenv("DAPR_HTTP_ENDPOINT", "")
+	t.Setenv("DAPR_HTTP_PORT", "")
+	t.Setenv("DAPR_API_TOKEN", "")
+	if want, got := (daprsvc.SidecarOptions{Endpoint: "http://127.0.0.1:3500"}), daprsvc.SidecarOptionsFromEnv(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected options %+v got %+v", want, got)
+	}
+
+	t.Setenv("DAPR_HTTP_PORT", "3601")
+	t.Setenv("DAPR_API_TOKEN", "secret")
+	if want, got := (daprsvc.SidecarOptions{Endpoint: "http://127.0.0.1:3601", ApiToken: "secret"}), daprsvc.SidecarOptionsFromEnv(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected options %+v got %+v", want, got)
+	}
+
+	t.Setenv("DAPR_HTTP_ENDPOINT", "https://dapr.example.com")
+	if want, got := (daprsvc.SidecarOptions{Endpoint: "https://dapr.example.com", ApiToken: "secret"}), daprsvc.SidecarOptionsFromEnv(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected options %+v got %+v", want, got)
+	}
+}
+
+func Test_Publish(t *testing.T) {
+	sidecar, requests := newTestSidecar(t, 204, "")
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc.SetSidecarOptions(daprsvc.SidecarOptions{Endpoint: sidecar.URL + "/", ApiToken: "secret"})
+
+	traceId := "0af7651916cd43dd8448eb211c80319c"
+	ctx := daprsvc.ContextWithTrace(context.Background(), daprsvc.TraceContext{TraceId: traceId, ParentId: "b7ad6b7169203331", Flags: 1})
+
+	if err := svc.Publish(ctx, "servicebus", "order/created", []byte("hello"), daprsvc.PublishOptions{ContentType: "text/plain", TtlInSeconds: 60, RawPayload: true, Metadata: map[string]string{"partitionKey": "p1"}}); err != nil {
+		t.Fatalf("Failed to publish: %s", err)
+	}
+	if err := svc.Sidecar().PublishJson(context.Background(), "servicebus", "order", map[string]int{"id": 1}, daprsvc.PublishOptions{}); err != nil {
+		t.Fatalf("Failed to publish json: %s", err)
+	}
+	event := daprsvc.CloudEvent{Id: "1234", Source: "test-case", Type: "order.created", DataContentType: "application/json", Data: json.RawMessage(`{"id":1}`)}
+	if err := svc.Sidecar().PublishCloudEvent(ctx, "servicebus", "order", event, daprsvc.PublishOptions{}); err != nil {
+		t.Fatalf("Failed to publish cloud-event: %s", err)
+	}
+
+	if want, got := 3, len(*requests); want != got {
+		t.Fatalf("Expected %d requests got %d", want, got)
+	}
+
+	raw := (*requests)[0]
+	if want, got := "POST /v1.0/publish/servicebus/order%2Fcreated", raw.method+" "+raw.path; want != got {
+		t.Errorf("Expected request '%s' got '%s'", want, got)
+	}
+	expectedQuery := url.Values{"metadata.ttlInSeconds": {"60"}, "metadata.rawPayload": {"true"}, "metadata.partitionKey": {"p1"}}
+	if want, got := expectedQuery, raw.query; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected query %v got %v", want, got)
+	}
+	if want, got := "text/plain", raw.header.Get("Content-Type"); want != got {
+		t.Errorf("Expected content-type '%s' got '%s'", want, got)
+	}
+	if want, got := "secret", raw.header.Get("Dapr-Api-Token"); want != got {
+		t.Errorf("Expected api token '%s' got '%s'", want, got)
+	}
+	if tc, err := daprsvc.TraceContextFromHeader(raw.header); err != nil || tc.TraceId != traceId || tc.ParentId == "b7ad6b7169203331" {
+		t.Errorf("Expected child span of trace '%s' got '%s' (error: %v)", traceId, raw.header.Get("Traceparent"), err)
+	}
+	if want, got := "hello", string(raw.body); want != got {
+		t.Errorf("Expected body '%s' got '%s'", want, got)
+	}
+
+	jsonReq := (*requests)[1]
+	if want, got := "application/json", jsonReq.header.Get("Content-Type"); want != got {
+		t.Errorf("Expected content-type '%s' got '%s'", want, got)
+	}
+	if want, got := "", jsonReq.header.Get("Traceparent"); want != got {
+		t.Errorf("Expected no traceparent got '%s'", got)
+	}
+	if want, got := `{"id":1}`, string(jsonReq.body); want != got {
+		t.Errorf("Expected body '%s' got '%s'", want, got)
+	}
+
+	ceReq := (*requests)[2]
+	if want, got := "application/cloudevents+json", ceReq.header.Get("Content-Type"); want != got {
+		t.Errorf("Expected content-type '%s' got '%s'", want, got)
+	}
+	var published daprsvc.CloudEvent
+	if err := json.Unmarshal(ceReq.body, &published); err != nil {
+		t.Fatalf("Failed to decode published cloud-event: %s", err)
+	}
+	if want, got := ceReq.header.Get("Traceparent"), published.Extensions["traceparent"]; want != got {
+		t.Errorf("Expected traceparent extension '%s' got '%v'", want, got)
+	}
+	if want, got := "order.created", published.Type; want != got {
+		t.Errorf("Expected type '%s' got '%s'", want, got)
+	}
+}
+
+func Test_PublishError(t *testing.T) {
+	sidecar, _ := newTestSidecar(t, 404, `{"errorCode":"ERR_PUBSUB_NOT_FOUND","message":"pubsub unknown not found"}`)
+	client := daprsvc.NewSidecarClient(daprsvc.SidecarOptions{Endpoint: sidecar.URL})
+
+	err := client.Publish(context.Background(), "unknown", "order", []byte("{}"), daprsvc.PublishOptions{})
+	var sidecarErr *daprsvc.SidecarError
+	if !errors.As(err, &sidecarErr) {
+		t.Fatalf("Expected sidecar error got %v", err)
+	}
+	if want, got := (daprsvc.SidecarError{StatusCode: 404, ErrorCode: "ERR_PUBSUB_NOT_FOUND", Message: "pubsub unknown not found"}), *sidecarErr; want != got {
+		t.Errorf("Expected error %+v got %+v", want, got)
+	}
+	expectedMsg := "Failed to publish to topic 'order' of pubsub 'unknown': Dapr daemon responded with status 404 (ERR_PUBSUB_NOT_FOUND): pubsub unknown not found"
+	if want, got := expectedMsg, err.Error(); want != got {
+		t.Errorf("Expected error message '%s' got '%s'", want, got)
+	}
+}
+
+func Test_BulkPublish(t *testing.T) {
+	sidecar, requests := newTestSidecar(t, 204, "")
+	svc := daprsvc.New()
+	svc.SetSidecarOptions(daprsvc.SidecarOptions{Endpoint: sidecar.URL})
+
+	messages := []daprsvc.Message{
+		{EntryId: "a", Data: []byte(`{"id":1}`), Metadata: map[string]string{"partitionKey": "p1"}},
+		{Data: []byte("hello"), ContentType: "text/plain"},
+	}
+	if err := svc.BulkPublish(context.Background(), "servicebus", "order", messages, daprsvc.PublishOptions{RawPayload: true}); err != nil {
+		t.Fatalf("Failed to bulk publish: %s", err)
+	}
+	events := []daprsvc.CloudEvent{{Id: "ce-1", Source: "test-case", Type: "order.created", Data: json.RawMessage(`{"id":2}`)}}
+	if err := svc.Sidecar().BulkPublishCloudEvents(context.Background(), "servicebus", "order", events, daprsvc.PublishOptions{}); err != nil {
+		t.Fatalf("Failed to bulk publish cloud-events: %s", err)
+	}
+	if err := svc.BulkPublish(context.Background(), "servicebus", "order", []daprsvc.Message{{Data: []byte("{")}}, daprsvc.PublishOptions{}); err == nil {
+		t.Errorf("Expected bulk publish of invalid json data to fail")
+	}
+	binaryMessages := []daprsvc.Message{{EntryId: "bin", Data: []byte{0xff, 0x00, 0x80}, ContentType: "application/octet-stream"}}
+	expectedErr := "Failed to bulk publish to topic 'order' of pubsub 'servicebus': entry 'bin' has binary data, which can't be bulk published."
+	if err := svc.BulkPublish(context.Background(), "servicebus", "order", binaryMessages, daprsvc.PublishOptions{}); err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected bulk publish of binary data to fail with '%s' got '%v'", expectedErr, err)
+	}
+
+	if want, got := 2, len(*requests); want != got {
+		t.Fatalf("Expected %d requests got %d", want, got)
+	}
+	req := (*requests)[0]
+	if want, got := "POST /v1.0-alpha1/publish/bulk/servicebus/order", req.method+" "+req.path; want != got {
+		t.Errorf("Expected request '%s' got '%s'", want, got)
+	}
+	if want, got := (url.Values{"metadata.rawPayload": {"true"}}), req.query; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected query %v got %v", want, got)
+	}
+	expectedBody := `[
+		{"entryId":"a","event":{"id":1},"contentType":"application/json","metadata":{"partitionKey":"p1"}},
+		{"entryId":"1","event":"hello","contentType":"text/plain"}
+	]`
+	if want, got := equalJson, IsEqualJson(expectedBody, req.body); want != got {
+		t.Errorf("Expected body '%s' got '%s'", expectedBody, string(req.body))
+	}
+
+	var entries []struct {
+		EntryId     string             `json:"entryId"`
+		Event       daprsvc.CloudEvent `json:"event"`
+		ContentType string             `json:"contentType"`
+	}
+	if err := json.Unmarshal((*requests)[1].body, &entries); err != nil || len(entries) != 1 {
+		t.Fatalf("Failed to decode bulk cloud-event entries: %v", err)
+	}
+	if want, got := "ce-1", entries[0].EntryId; want != got {
+		t.Errorf("Expected entry id '%s' got '%s'", want, got)
+	}
+	if want, got := "application/cloudevents+json", entries[0].ContentType; want != got {
+		t.Errorf("Expected content-type '%s' got '%s'", want, got)
+	}
+	if want, got := "order.created", entries[0].Event.Type; want != got {
+		t.Errorf("Expected event type '%s' got '%s'", want, got)
+	}
+}
+
+func Test_BulkPublishFailedEntries(t *testing.T) {
+	sidecar, _ := newTestSidecar(t, 500, `{"failedEntries":[{"entryId":"b","error":"broker unavailable"}],"errorCode":"ERR_PUBSUB_PUBLISH_MESSAGE"}`)
+	client := daprsvc.NewSidecarClient(daprsvc.SidecarOptions{Endpoint: sidecar.URL})
+
+	messages := []daprsvc.Message{{EntryId: "a", Data: []byte(`{}`)}, {EntryId: "b", Data: []byte(`{}`)}}
+	err := client.BulkPublish(context.Background(), "servicebus", "order", messages, daprsvc.PublishOptions{})
+
+	var bulkErr *daprsvc.BulkPublishError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("Expected bulk publish error got %v", err)
+	}
+	if want, got := 1, len(bulkErr.FailedEntries); want != got {
+		t.Fatalf("Expected %d failed entries got %d", want, got)
+	}
+	if want, got := "broker unavailable", bulkErr.FailedEntries["b"].Error(); want != got {
+		t.Errorf("Expected entry error '%s' got '%s'", want, got)
+	}
+	var sidecarErr *daprsvc.SidecarError
+	if !errors.As(err, &sidecarErr) || sidecarErr.ErrorCode != "ERR_PUBSUB_PUBLISH_MESSAGE" {
+		t.Errorf("Expected sidecar error with error code got %v", err)
+	}
+	expectedMsg := "Failed to bulk publish 1 entries to topic 'order' of pubsub 'servicebus': entry 'b': broker unavailable"
+	if want, got := expectedMsg, err.Error(); want != got {
+		t.Errorf("Expected error message '%s' got '%s'", want, got)
+	}
+}
+
+func Test_Invoke(t *testing.T) {
+	sidecar, requests := newTestSidecar(t, 200, `{"total":3}`)
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc.SetSidecarOptions(daprsvc.SidecarOptions{Endpoint: sidecar.URL, ApiToken: "secret"})
+
+	type sumRequest struct {
+		Values []int `json:"values"`
+	}
+	type sumResponse struct {
+		Total int `json:"total"`
+	}
+
+	// An incoming invocation request calls another app, propagating its trace.
+	var response sumResponse
+	var invokeErr error
+	svc.SetInvocationHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, invokeErr = daprsvc.Invoke[sumRequest, sumResponse](r.Context(), svc.Sidecar(), http.MethodPut, "calculator", "sums/v1", sumRequest{Values: []int{1, 2}})
+	}))
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	req := httptest.NewRequest("GET", "/calculate", nil)
+	req.Header.Set("Traceparent", traceparent)
+	doInvocationRequest(svc.HttpHandler(), req)
+
+	if invokeErr != nil {
+		t.Fatalf("Failed to invoke: %s", invokeErr)
+	}
+	if want, got := 3, response.Total; want != got {
+		t.Errorf("Expected total %d got %d", want, got)
+	}
+
+	healthResp, err := svc.InvokeMethod(context.Background(), "calculator", "health", daprsvc.InvokeRequest{HttpMethod: http.MethodGet, Query: url.Values{"verbose": {"1"}}})
+	if err != nil {
+		t.Fatalf("Failed to invoke method: %s", err)
+	}
+	if want, got := 200, healthResp.StatusCode; want != got {
+		t.Errorf("Expected status %d got %d", want, got)
+	}
+
+	if want, got := 2, len(*requests); want != got {
+		t.Fatalf("Expected %d requests got %d", want, got)
+	}
+	invoked := (*requests)[0]
+	if want, got := "PUT /v1.0/invoke/calculator/method/sums/v1", invoked.method+" "+invoked.path; want != got {
+		t.Errorf("Expected request '%s' got '%s'", want, got)
+	}
+	if want, got := `{"values":[1,2]}`, string(invoked.body); want != got {
+		t.Errorf("Expected body '%s' got '%s'", want, got)
+	}
+	if want, got := "application/json", invoked.header.Get("Content-Type"); want != got {
+		t.Errorf("Expected content-type '%s' got '%s'", want, got)
+	}
+	if want, got := "secret", invoked.header.Get("Dapr-Api-Token"); want != got {
+		t.Errorf("Expected api token '%s' got '%s'", want, got)
+	}
+	if tc, err := daprsvc.TraceContextFromHeader(invoked.header); err != nil || tc.TraceId != "0af7651916cd43dd8448eb211c80319c" || tc.ParentId == "b7ad6b7169203331" {
+		t.Errorf("Expected child span of incoming trace got '%s' (error: %v)", invoked.header.Get("Traceparent"), err)
+	}
+
+	health := (*requests)[1]
+	if want, got := "GET /v1.0/invoke/calculator/method/health", health.method+" "+health.path; want != got {
+		t.Errorf("Expected request '%s' got '%s'", want, got)
+	}
+	if want, got := (url.Values{"verbose": {"1"}}), health.query; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected query %v got %v", want, got)
+	}
+}
+
+func Test_InvokeError(t *testing.T) {
+	sidecar, _ := newTestSidecar(t, 422, `invalid values`)
+	client := daprsvc.NewSidecarClient(daprsvc.SidecarOptions{Endpoint: sidecar.URL})
+
+	_, err := daprsvc.Invoke[[]int, int](context.Background(), client, http.MethodPost, "calculator", "sum", []int{1})
+	var invocationErr *daprsvc.InvocationError
+	if !errors.As(err, &invocationErr) {
+		t.Fatalf("Expected invocation error got %v", err)
+	}
+	if want, got := 422, invocationErr.StatusCode; want != got {
+		t.Errorf("Expected status %d got %d", want, got)
+	}
+	if want, got := "invalid values", string(invocationErr.Body); want != got {
+		t.Errorf("Expected body '%s' got '%s'", want, got)
+	}
+	expectedMsg := "Invocation of method 'sum' of app 'calculator' failed: Dapr daemon responded with status 422: invalid values"
+	if want, got := expectedMsg, err.Error(); want != got {
+		t.Errorf("Expected error message '%s' got '%s'", want, got)
+	}
+}
+
+func Test_State(t *testing.T) {
+	requests := []sidecarRequest{}
+	sidecar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, sidecarRequest{method: r.Method, path: r.URL.EscapedPath(), query: r.URL.Query(), header: r.Header, body: body})
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v1.0/state/statestore/order-1":
+			w.Header().Set("ETag", "7")
+			w.Write([]byte(`{"id":1}`))
+		case "GET /v1.0/state/statestore/order-2":
+			w.WriteHeader(204)
+		case "POST /v1.0/state/statestore/bulk":
+			w.Write([]byte(`[{"key":"order-2"},{"key":"order-3","error":"timeout"},{"key":"order-1","data":{"id":1},"etag":"7"}]`))
+		case "DELETE /v1.0/state/statestore/order-1":
+			w.WriteHeader(409)
+			w.Write([]byte(`{"errorCode":"ERR_STATE_DELETE","message":"possible etag mismatch"}`))
+		default:
+			w.WriteHeader(204)
+		}
+	}))
+	t.Cleanup(sidecar.Close)
+	client := daprsvc.NewSidecarClient(daprsvc.SidecarOptions{Endpoint: sidecar.URL})
+	ctx := context.Background()
+
+	entry, err := client.GetState(ctx, "statestore", "order-1", daprsvc.GetStateOptions{Consistency: daprsvc.StateStrong, Metadata: map[string]string{"partitionKey": "p1"}})
+	if err != nil {
+		t.Fatalf("Failed to get state: %s", err)
+	}
+	var order struct {
+		Id int `json:"id"`
+	}
+	if err := entry.Json(&order); err != nil || order.Id != 1 || entry.Etag != "7" {
+		t.Errorf("Expected order 1 with etag '7' got %+v with etag '%s' (error: %v)", order, entry.Etag, err)
+	}
+	if want, got := (url.Values{"consistency": {"strong"}, "metadata.partitionKey": {"p1"}}), requests[0].query; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected query %v got %v", want, got)
+	}
+
+	missing, err := client.GetState(ctx, "statestore", "order-2", daprsvc.GetStateOptions{})
+	if err != nil || missing.Found() {
+		t.Errorf("Expected no state for key 'order-2' got %+v (error: %v)", missing, err)
+	}
+
+	err = client.SaveState(ctx, "statestore",
+		daprsvc.StateItem{Key: "order-1", Value: order, Etag: "7", TtlInSeconds: 120, Options: daprsvc.StateOptions{Concurrency: daprsvc.StateFirstWrite}},
+		daprsvc.StateItem{Key: "order-3", Value: json.RawMessage(`"raw"`)},
+	)
+	if err != nil {
+		t.Fatalf("Failed to save state: %s", err)
+	}
+	expectedSaveBody := `[
+		{"key":"order-1","value":{"id":1},"etag":"7","metadata":{"ttlInSeconds":"120"},"options":{"concurrency":"first-write"}},
+		{"key":"order-3","value":"raw"}
+	]`
+	if want, got := equalJson, IsEqualJson(expectedSaveBody, requests[2].body); want != got {
+		t.Errorf("Expected save body '%s' got '%s'", expectedSaveBody, string(requests[2].body))
+	}
+
+	entries, err := client.GetBulkState(ctx, "statestore", []string{"order-1", "order-2", "order-3"}, daprsvc.BulkGetStateOptions{Parallelism: 2})
+	if err != nil {
+		t.Fatalf("Failed to get bulk state: %s", err)
+	}
+	if want, got := 3, len(entries); want != got {
+		t.Fatalf("Expected %d entries got %d", want, got)
+	}
+	failedEntry := entries[2]
+	entries[2].Error = nil
+	expectedEntries := []daprsvc.StateEntry{{Key: "order-1", Value: json.RawMessage(`{"id":1}`), Etag: "7"}, {Key: "order-2"}, {Key: "order-3"}}
+	if want, got := expectedEntries, entries; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected entries %+v got %+v", want, got)
+	}
+	expectedEntryErr := "Failed to get state 'order-3' from store 'statestore': timeout"
+	if failedEntry.Error == nil || failedEntry.Error.Error() != expectedEntryErr || failedEntry.Found() {
+		t.Errorf("Expected entry without value and with error '%s' got %+v", expectedEntryErr, failedEntry)
+	}
+	if err := failedEntry.Json(&order); err != failedEntry.Error {
+		t.Errorf("Expected decoding the failed entry to return its error got %v", err)
+	}
+	if want, got := equalJson, IsEqualJson(`{"keys":["order-1","order-2","order-3"],"parallelism":2}`, requests[3].body); want != got {
+		t.Errorf("Expected bulk get body got '%s'", string(requests[3].body))
+	}
+
+	err = client.DeleteState(ctx, "statestore", "order-1", daprsvc.DeleteStateOptions{Etag: "6", Options: daprsvc.StateOptions{Concurrency: daprsvc.StateFirstWrite}})
+	if !daprsvc.IsEtagMismatch(err) {
+		t.Errorf("Expected etag mismatch error got %v", err)
+	}
+	if want, got := "6", requests[4].header.Get("If-Match"); want != got {
+		t.Errorf("Expected If-Match header '%s' got '%s'", want, got)
+	}
+	if want, got := (url.Values{"concurrency": {"first-write"}}), requests[4].query; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected query %v got %v", want, got)
+	}
+
+	err = client.ExecuteStateTransaction(ctx, "statestore", []daprsvc.StateOperation{
+		{Type: daprsvc.StateUpsert, Item: daprsvc.StateItem{Key: "order-1", Value: 2}},
+		{Type: daprsvc.StateDelete, Item: daprsvc.StateItem{Key: "order-3", Etag: "1"}},
+	}, map[string]string{"partitionKey": "p1"})
+	if err != nil {
+		t.Fatalf("Failed to execute state transaction: %s", err)
+	}
+	expectedTransactionBody := `{
+		"operations":[
+			{"operation":"upsert","request":{"key":"order-1","value":2}},
+			{"operation":"delete","request":{"key":"order-3","etag":"1"}}
+		],
+		"metadata":{"partitionKey":"p1"}
+	}`
+	if want, got := "POST /v1.0/state/statestore/transaction", requests[5].method+" "+requests[5].path; want != got {
+		t.Errorf("Expected request '%s' got '%s'", want, got)
+	}
+	if want, got := equalJson, IsEqualJson(expectedTransactionBody, requests[5].body); want != got {
+		t.Errorf("Expected transaction body '%s' got '%s'", expectedTransactionBody, string(requests[5].body))
+	}
+
+	if err := client.ExecuteStateTransaction(ctx, "statestore", []daprsvc.StateOperation{{Type: "merge", Item: daprsvc.StateItem{Key: "x"}}}, nil); err == nil {
+		t.Errorf("Expected invalid state operation to fail")
+	}
+}
+
+func Test_MetricsInvocationRouteLabel(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc.SetMetricsRoute("/metrics")
+	svc.SetInvocationRouteLabeler(func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/orders/") {
+			return "/orders/:id"
+		}
+		return ""
+	})
+	handler := svc.HttpHandler()
+
+	doInvocationRequest(handler, httptest.NewRequest("GET", "/orders/1", nil))
+	doInvocationRequest(handler, httptest.NewRequest("GET", "/orders/2", nil))
+	doInvocationRequest(handler, httptest.NewRequest("GET", "/hello", nil))
+
+	wrec := httptest.NewRecorder()
+	handler.ServeHTTP(wrec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(wrec.Result().Body)
+
+	requestSeries := []string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, "daprsvc_invocation_requests_total{") {
+			requestSeries = append(requestSeries, line)
+		}
+	}
+	expectedSeries := []string{
+		`daprsvc_invocation_requests_total{method="GET",route="*",code="404"} 1`,
+		`daprsvc_invocation_requests_total{method="GET",route="/orders/:id",code="404"} 2`,
+	}
+	if want, got := expectedSeries, requestSeries; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected invocation request series %v got %v", want, got)
+	}
+}
+
+func Test_MetricsRouteEmpty(t *testing.T) {
+	svc := daprsvc.New()
+	svc.SetMetricsRoute("/")
+	expectedErr := "Metrics route '/' is empty after normalization."
+	if err := svc.Validate(); err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected validation error '%s' got '%v'", expectedErr, err)
+	}
+	if _, err := svc.BuildHttpHandler(); err == nil {
+		t.Errorf("Expected building the http handler to fail")
+	}
+
+	svc.SetMetricsRoute("")
+	if err := svc.Validate(); err != nil {
+		t.Errorf("Expected no validation error without metrics route got '%v'", err)
+	}
+}
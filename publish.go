@@ -0,0 +1,210 @@
+package daprsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type PublishOptions struct {
+	ContentType  string            // NOTE: Content-type of the data, "application/json" by default.
+	Metadata     map[string]string // NOTE: Metadata for the pubsub component, sent as metadata.<key> query parameters.
+	TtlInSeconds int               // NOTE: If set, the message expires when not delivered within this time.
+	// NOTE: If true, the data is delivered to subscribers as is, instead of wrapped in a cloud-event by the dapr
+	// daemon.
+	RawPayload bool
+}
+
+func (options PublishOptions) query() url.Values {
+	query := metadataQuery(nil, options.Metadata)
+	if options.TtlInSeconds > 0 {
+		query.Set("metadata.ttlInSeconds", strconv.Itoa(options.TtlInSeconds))
+	}
+	if options.RawPayload {
+		query.Set("metadata.rawPayload", "true")
+	}
+	return query
+}
+
+func publishPath(pubsubName string, topic string) string {
+	return "/v1.0/publish/" + url.PathEscape(pubsubName) + "/" + url.PathEscape(topic)
+}
+
+func bulkPublishPath(pubsubName string, topic string) string {
+	return "/v1.0-alpha1/publish/bulk/" + url.PathEscape(pubsubName) + "/" + url.PathEscape(topic)
+}
+
+func (sc *SidecarClient) publish(ctx context.Context, pubsubName string, topic string, header http.Header, data []byte, options PublishOptions) error {
+	if _, _, err := sc.do(ctx, http.MethodPost, publishPath(pubsubName, topic), options.query(), header, data); err != nil {
+		return fmt.Errorf("Failed to publish to topic '%s' of pubsub '%s': %w", topic, pubsubName, err)
+	}
+	return nil
+}
+
+// Publishes the data to the topic. The dapr daemon wraps it in a cloud-event, unless RawPayload is set.
+func (sc *SidecarClient) Publish(ctx context.Context, pubsubName string, topic string, data []byte, options PublishOptions) error {
+	contentType := options.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return sc.publish(ctx, pubsubName, topic, http.Header{"Content-Type": {contentType}}, data, options)
+}
+
+// Publishes the value, encoded as json, to the topic.
+func (sc *SidecarClient) PublishJson(ctx context.Context, pubsubName string, topic string, v any, options PublishOptions) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Failed to encode data for topic '%s' of pubsub '%s': %w", topic, pubsubName, err)
+	}
+	return sc.publish(ctx, pubsubName, topic, http.Header{"Content-Type": {"application/json"}}, data, options)
+}
+
+// Publishes the cloud-event as is, in structured content mode. The trace context of the context is added as
+// traceparent and tracestate extensions when the event has none. The ContentType of the options is ignored, the
+// content-type of the data is the DataContentType of the event.
+func (sc *SidecarClient) PublishCloudEvent(ctx context.Context, pubsubName string, topic string, event CloudEvent, options PublishOptions) error {
+	header := http.Header{"Content-Type": {cloudEventContentType}}
+	if tc, ok := TraceFromContext(ctx); ok && event.extensionString("traceparent") == "" {
+		// NOTE: The event and the request carry the same span.
+		child := tc.NewChild()
+		child.SetHeader(header)
+		extensions := make(map[string]any, len(event.Extensions)+2)
+		for name, value := range event.Extensions {
+			extensions[name] = value
+		}
+		extensions["traceparent"] = child.Traceparent()
+		if tracestate := child.Tracestate(); tracestate != "" {
+			extensions["tracestate"] = tracestate
+		}
+		event.Extensions = extensions
+	}
+
+	envelope, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Failed to encode cloud-event for topic '%s' of pubsub '%s': %w", topic, pubsubName, err)
+	}
+	return sc.publish(ctx, pubsubName, topic, header, envelope, options)
+}
+
+// Error of a bulk publish, in which the dapr daemon failed to publish some or all of the entries.
+type BulkPublishError struct {
+	PubsubName    string
+	Topic         string
+	FailedEntries map[string]error // NOTE: Errors by entry id, empty when the failed entries are not known.
+	Err           error            // NOTE: The error response of the dapr daemon.
+}
+
+func (err *BulkPublishError) Error() string {
+	if len(err.FailedEntries) == 0 {
+		return fmt.Sprintf("Failed to bulk publish to topic '%s' of pubsub '%s': %s", err.Topic, err.PubsubName, err.Err)
+	}
+	descriptions := make([]string, 0, len(err.FailedEntries))
+	for _, entryId := range sortedKeys(err.FailedEntries) {
+		descriptions = append(descriptions, fmt.Sprintf("entry '%s': %s", entryId, err.FailedEntries[entryId]))
+	}
+	return fmt.Sprintf("Failed to bulk publish %d entries to topic '%s' of pubsub '%s': %s", len(err.FailedEntries), err.Topic, err.PubsubName, strings.Join(descriptions, "; "))
+}
+
+func (err *BulkPublishError) Unwrap() error {
+	return err.Err
+}
+
+// Publishes the messages to the topic in one request. Of each message, the EntryId, Data, ContentType and Metadata
+// are published; the index of the message is used as entry id when it has none, and the ContentType of the options
+// when it has no content-type. Data of other than json content-types must be text. When publishing fails for some
+// entries, the error is a *BulkPublishError.
+func (sc *SidecarClient) BulkPublish(ctx context.Context, pubsubName string, topic string, messages []Message, options PublishOptions) error {
+	type bulkPublishEntry struct {
+		EntryId     string            `json:"entryId"`
+		Event       json.RawMessage   `json:"event"`
+		ContentType string            `json:"contentType"`
+		Metadata    map[string]string `json:"metadata,omitempty"`
+	}
+
+	entries := make([]bulkPublishEntry, 0, len(messages))
+	for i, msg := range messages {
+		entry := bulkPublishEntry{EntryId: msg.EntryId, ContentType: msg.ContentType, Metadata: msg.Metadata}
+		if entry.EntryId == "" {
+			entry.EntryId = strconv.Itoa(i)
+		}
+		if entry.ContentType == "" {
+			entry.ContentType = options.ContentType
+		}
+		if entry.ContentType == "" {
+			entry.ContentType = "application/json"
+		}
+
+		// NOTE: The event is the json value itself for json content-types, and the data as json string otherwise. A
+		// json string can't hold binary data, so that has to be published with Publish instead.
+		if (Message{ContentType: entry.ContentType}).ContainsJsonData() {
+			if !json.Valid(msg.Data) {
+				return fmt.Errorf("Failed to bulk publish to topic '%s' of pubsub '%s': entry '%s' has invalid json data.", topic, pubsubName, entry.EntryId)
+			}
+			entry.Event = msg.Data
+		} else {
+			if !utf8.Valid(msg.Data) {
+				return fmt.Errorf("Failed to bulk publish to topic '%s' of pubsub '%s': entry '%s' has binary data, which can't be bulk published.", topic, pubsubName, entry.EntryId)
+			}
+			entry.Event, _ = json.Marshal(string(msg.Data))
+		}
+		entries = append(entries, entry)
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("Failed to encode bulk publish request for topic '%s' of pubsub '%s': %w", topic, pubsubName, err)
+	}
+
+	_, respBody, err := sc.do(ctx, http.MethodPost, bulkPublishPath(pubsubName, topic), options.query(), http.Header{"Content-Type": {"application/json"}}, body)
+	if err == nil {
+		return nil
+	}
+	var sidecarErr *SidecarError
+	if !errors.As(err, &sidecarErr) {
+		return fmt.Errorf("Failed to bulk publish to topic '%s' of pubsub '%s': %w", topic, pubsubName, err)
+	}
+
+	bulkErr := &BulkPublishError{PubsubName: pubsubName, Topic: topic, FailedEntries: map[string]error{}, Err: err}
+	failures := struct {
+		FailedEntries []struct {
+			EntryId string `json:"entryId"`
+			Error   string `json:"error"`
+		} `json:"failedEntries"`
+	}{}
+	if json.Unmarshal(respBody, &failures) == nil {
+		for _, failure := range failures.FailedEntries {
+			bulkErr.FailedEntries[failure.EntryId] = errors.New(failure.Error)
+		}
+	}
+	return bulkErr
+}
+
+// Publishes the cloud-events to the topic in one request, in structured content mode, with the event ids as entry
+// ids.
+func (sc *SidecarClient) BulkPublishCloudEvents(ctx context.Context, pubsubName string, topic string, events []CloudEvent, options PublishOptions) error {
+	messages := make([]Message, 0, len(events))
+	for _, event := range events {
+		envelope, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("Failed to encode cloud-event '%s' for topic '%s' of pubsub '%s': %w", event.Id, topic, pubsubName, err)
+		}
+		messages = append(messages, Message{EntryId: event.Id, Data: envelope, ContentType: cloudEventContentType})
+	}
+	return sc.BulkPublish(ctx, pubsubName, topic, messages, options)
+}
+
+// Publishes the data to the topic with the client for the dapr daemon of the service.
+func (svc *daprSvc) Publish(ctx context.Context, pubsubName string, topic string, data []byte, options PublishOptions) error {
+	return svc.sidecar.Publish(ctx, pubsubName, topic, data, options)
+}
+
+// Publishes the messages to the topic in one request with the client for the dapr daemon of the service.
+func (svc *daprSvc) BulkPublish(ctx context.Context, pubsubName string, topic string, messages []Message, options PublishOptions) error {
+	return svc.sidecar.BulkPublish(ctx, pubsubName, topic, messages, options)
+}
@@ -0,0 +1,604 @@
+package daprsvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const maxSchemaRefDepth = 64
+
+// A single way in which message data does not match a schema.
+type SchemaViolation struct {
+	Path    string // NOTE: JSON pointer to the offending value in the data, empty for the data itself.
+	Message string
+}
+
+type SchemaValidationError struct {
+	Schema     string
+	Violations []SchemaViolation
+}
+
+func (err *SchemaValidationError) Error() string {
+	descriptions := make([]string, 0, len(err.Violations))
+	for _, violation := range err.Violations {
+		descriptions = append(descriptions, fmt.Sprintf("'%s': %s", "/"+strings.TrimPrefix(violation.Path, "/"), violation.Message))
+	}
+	return fmt.Sprintf("Message data does not match schema '%s': %s", err.Schema, strings.Join(descriptions, "; "))
+}
+
+// JSON Schema documents by URI. Validation supports the commonly used keywords of the recent drafts: type, enum,
+// const, the numeric, string, array and object constraints, the allOf/anyOf/oneOf/not combinators, and $ref to
+// definitions within a document or to other registered documents. Unknown keywords, like format, are ignored.
+type schemaRegistry struct {
+	mu        sync.RWMutex
+	documents map[string]any
+	aliases   map[string]string // NOTE: Document URIs by alias; references resolve against the document URI.
+	patterns  sync.Map          // NOTE: Compiled pattern regexps by pattern.
+}
+
+func decodeJsonWithNumbers(data []byte) (any, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("Unexpected data after json value.")
+	}
+	return value, nil
+}
+
+// Strips the fragment from a schema URI, as documents are registered without one.
+func schemaDocumentUri(uri string) string {
+	documentUri, _, _ := strings.Cut(uri, "#")
+	return documentUri
+}
+
+// Resolves a reference against the base URI. Relative base URIs, like the paths of loaded documents, stay relative.
+func resolveSchemaUri(baseUri string, ref string) string {
+	base, err := url.Parse(baseUri)
+	if err != nil {
+		return ref
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	if base.IsAbs() || base.Host != "" || strings.HasPrefix(base.Path, "/") {
+		return base.ResolveReference(refUrl).String()
+	}
+	rootedBase := *base
+	rootedBase.Path = "/" + base.Path
+	resolved := rootedBase.ResolveReference(refUrl)
+	if !resolved.IsAbs() && resolved.Host == "" {
+		resolved.Path = strings.TrimPrefix(resolved.Path, "/")
+	}
+	return resolved.String()
+}
+
+func (sr *schemaRegistry) register(uri string, document []byte) error {
+	schema, err := decodeJsonWithNumbers(document)
+	if err != nil {
+		return fmt.Errorf("Failed to parse schema '%s': %w", uri, err)
+	}
+	switch schema.(type) {
+	case map[string]any, bool:
+	default:
+		return fmt.Errorf("Schema '%s' is not an object or boolean.", uri)
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.documents == nil {
+		sr.documents = make(map[string]any)
+	}
+	sr.documents[schemaDocumentUri(uri)] = schema
+	return nil
+}
+
+// Makes the registered document available under the alias as well.
+func (sr *schemaRegistry) alias(alias string, uri string) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.aliases == nil {
+		sr.aliases = make(map[string]string)
+	}
+	sr.aliases[schemaDocumentUri(alias)] = schemaDocumentUri(uri)
+}
+
+// Returns the document and its URI, following an alias.
+func (sr *schemaRegistry) document(documentUri string) (any, string, bool) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	if document, found := sr.documents[documentUri]; found {
+		return document, documentUri, true
+	}
+	if aliasedUri, isAlias := sr.aliases[documentUri]; isAlias {
+		document, found := sr.documents[aliasedUri]
+		return document, aliasedUri, found
+	}
+	return nil, "", false
+}
+
+func (sr *schemaRegistry) has(uri string) bool {
+	if sr == nil {
+		return false
+	}
+	_, _, found := sr.document(schemaDocumentUri(uri))
+	return found
+}
+
+// Resolves a schema URI, optionally with a JSON pointer fragment, to the schema and the URI of its document.
+func (sr *schemaRegistry) resolve(uri string) (any, string, error) {
+	documentUri, fragment, _ := strings.Cut(uri, "#")
+
+	document, documentUri, found := sr.document(documentUri)
+	if !found {
+		return nil, "", fmt.Errorf("Schema '%s' is not registered.", uri)
+	}
+
+	schema := document
+	if fragment != "" && fragment != "/" {
+		unescaped, err := url.PathUnescape(fragment)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid schema reference '%s'.", uri)
+		}
+		for _, token := range strings.Split(strings.TrimPrefix(unescaped, "/"), "/") {
+			token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+			switch node := schema.(type) {
+			case map[string]any:
+				schema, found = node[token]
+			case []any:
+				idx, err := strconv.Atoi(token)
+				found = err == nil && idx >= 0 && idx < len(node)
+				if found {
+					schema = node[idx]
+				}
+			default:
+				found = false
+			}
+			if !found {
+				return nil, "", fmt.Errorf("Schema reference '%s' does not resolve.", uri)
+			}
+		}
+	}
+	return schema, documentUri, nil
+}
+
+func (sr *schemaRegistry) pattern(pattern string) (*regexp.Regexp, error) {
+	if cached, found := sr.patterns.Load(pattern); found {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sr.patterns.Store(pattern, re)
+	return re, nil
+}
+
+// Validates the data, which must be json, against the registered schema.
+func (sr *schemaRegistry) validate(uri string, data []byte) error {
+	schema, documentUri, err := sr.resolve(uri)
+	if err != nil {
+		return err
+	}
+	value, err := decodeJsonWithNumbers(data)
+	if err != nil {
+		return &SchemaValidationError{Schema: uri, Violations: []SchemaViolation{{Message: "data is not valid json"}}}
+	}
+
+	sv := schemaValidation{registry: sr}
+	sv.validate(schema, documentUri, value, "", 0)
+	if len(sv.violations) > 0 {
+		return &SchemaValidationError{Schema: uri, Violations: sv.violations}
+	}
+	return nil
+}
+
+type schemaValidation struct {
+	registry   *schemaRegistry
+	violations []SchemaViolation
+}
+
+func (sv *schemaValidation) fail(path string, format string, args ...any) {
+	sv.violations = append(sv.violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Checks the value against a subschema without reporting its violations.
+func (sv *schemaValidation) matches(schema any, baseUri string, value any, depth int) bool {
+	sub := schemaValidation{registry: sv.registry}
+	sub.validate(schema, baseUri, value, "", depth)
+	return len(sub.violations) == 0
+}
+
+func jsonPointerAppend(path string, token string) string {
+	return path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func jsonTypeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func jsonTypeMatches(value any, typeName string) bool {
+	actual := jsonTypeOf(value)
+	return actual == typeName || (typeName == "number" && actual == "integer")
+}
+
+func schemaNumber(value any) (float64, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := number.Float64()
+	return f, err == nil
+}
+
+func jsonEqual(a any, b any) bool {
+	switch av := a.(type) {
+	case json.Number:
+		af, aok := schemaNumber(av)
+		bf, bok := schemaNumber(b)
+		return aok && bok && af == bf
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, value := range av {
+			if other, found := bv[key]; !found || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func (sv *schemaValidation) validate(schema any, baseUri string, value any, path string, depth int) {
+	if depth > maxSchemaRefDepth {
+		sv.fail(path, "schema references nest too deep")
+		return
+	}
+
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			sv.fail(path, "no value is allowed")
+		}
+		return
+	case map[string]any:
+		sv.validateObjectSchema(s, baseUri, value, path, depth)
+	}
+}
+
+func (sv *schemaValidation) validateObjectSchema(schema map[string]any, baseUri string, value any, path string, depth int) {
+	if id, ok := schema["$id"].(string); ok && depth > 0 {
+		baseUri = schemaDocumentUri(resolveSchemaUri(baseUri, id))
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target := resolveSchemaUri(baseUri, ref)
+		if strings.HasPrefix(ref, "#") {
+			target = baseUri + ref
+		}
+		refSchema, refDocumentUri, err := sv.registry.resolve(target)
+		if err != nil {
+			sv.fail(path, "%s", err.Error())
+		} else {
+			sv.validate(refSchema, refDocumentUri, value, path, depth+1)
+		}
+	}
+
+	switch t := schema["type"].(type) {
+	case string:
+		if !jsonTypeMatches(value, t) {
+			sv.fail(path, "expected %s, got %s", t, jsonTypeOf(value))
+			return
+		}
+	case []any:
+		names := make([]string, 0, len(t))
+		matched := false
+		for _, name := range t {
+			if typeName, ok := name.(string); ok {
+				names = append(names, typeName)
+				matched = matched || jsonTypeMatches(value, typeName)
+			}
+		}
+		if !matched {
+			sv.fail(path, "expected %s, got %s", strings.Join(names, " or "), jsonTypeOf(value))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || jsonEqual(value, allowed)
+		}
+		if !found {
+			sv.fail(path, "value is not one of the allowed values")
+		}
+	}
+	if constValue, ok := schema["const"]; ok && !jsonEqual(value, constValue) {
+		sv.fail(path, "value does not equal the constant value")
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		sv.validateNumber(schema, v, path)
+	case string:
+		sv.validateString(schema, v, path)
+	case []any:
+		sv.validateArray(schema, baseUri, v, path, depth)
+	case map[string]any:
+		sv.validateObject(schema, baseUri, v, path, depth)
+	}
+
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			sv.validate(sub, baseUri, value, path, depth+1)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if sv.matches(sub, baseUri, value, depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			sv.fail(path, "value does not match any of the schemas in anyOf")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		matchCount := 0
+		for _, sub := range oneOf {
+			if sv.matches(sub, baseUri, value, depth+1) {
+				matchCount++
+			}
+		}
+		if matchCount != 1 {
+			sv.fail(path, "value matches %d of the schemas in oneOf, instead of exactly one", matchCount)
+		}
+	}
+	if not, ok := schema["not"]; ok && sv.matches(not, baseUri, value, depth+1) {
+		sv.fail(path, "value matches the schema in not")
+	}
+}
+
+func (sv *schemaValidation) validateNumber(schema map[string]any, number json.Number, path string) {
+	value, ok := schemaNumber(number)
+	if !ok {
+		return
+	}
+	if minimum, ok := schemaNumber(schema["minimum"]); ok && value < minimum {
+		sv.fail(path, "value %s is less than minimum %s", number, formatMetricValue(minimum))
+	}
+	if maximum, ok := schemaNumber(schema["maximum"]); ok && value > maximum {
+		sv.fail(path, "value %s is greater than maximum %s", number, formatMetricValue(maximum))
+	}
+	if minimum, ok := schemaNumber(schema["exclusiveMinimum"]); ok && value <= minimum {
+		sv.fail(path, "value %s is not greater than exclusive minimum %s", number, formatMetricValue(minimum))
+	}
+	if maximum, ok := schemaNumber(schema["exclusiveMaximum"]); ok && value >= maximum {
+		sv.fail(path, "value %s is not less than exclusive maximum %s", number, formatMetricValue(maximum))
+	}
+	if multipleOf, ok := schemaNumber(schema["multipleOf"]); ok && multipleOf > 0 {
+		if quotient := value / multipleOf; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			sv.fail(path, "value %s is not a multiple of %s", number, formatMetricValue(multipleOf))
+		}
+	}
+}
+
+func (sv *schemaValidation) validateString(schema map[string]any, value string, path string) {
+	length := utf8.RuneCountInString(value)
+	if minLength, ok := schemaNumber(schema["minLength"]); ok && float64(length) < minLength {
+		sv.fail(path, "string is shorter than %s characters", formatMetricValue(minLength))
+	}
+	if maxLength, ok := schemaNumber(schema["maxLength"]); ok && float64(length) > maxLength {
+		sv.fail(path, "string is longer than %s characters", formatMetricValue(maxLength))
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re, err := sv.registry.pattern(pattern); err != nil {
+			sv.fail(path, "invalid pattern '%s' in schema", pattern)
+		} else if !re.MatchString(value) {
+			sv.fail(path, "string does not match pattern '%s'", pattern)
+		}
+	}
+}
+
+func (sv *schemaValidation) validateArray(schema map[string]any, baseUri string, value []any, path string, depth int) {
+	if minItems, ok := schemaNumber(schema["minItems"]); ok && float64(len(value)) < minItems {
+		sv.fail(path, "array has fewer than %s items", formatMetricValue(minItems))
+	}
+	if maxItems, ok := schemaNumber(schema["maxItems"]); ok && float64(len(value)) > maxItems {
+		sv.fail(path, "array has more than %s items", formatMetricValue(maxItems))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+	uniqueCheck:
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if jsonEqual(value[i], value[j]) {
+					sv.fail(path, "items %d and %d are equal", i, j)
+					break uniqueCheck
+				}
+			}
+		}
+	}
+
+	prefixCount := 0
+	if prefixItems, ok := schema["prefixItems"].([]any); ok {
+		for i := 0; i < len(prefixItems) && i < len(value); i++ {
+			sv.validate(prefixItems[i], baseUri, value[i], jsonPointerAppend(path, strconv.Itoa(i)), depth+1)
+		}
+		prefixCount = len(prefixItems)
+	}
+	if items, ok := schema["items"]; ok {
+		for i := prefixCount; i < len(value); i++ {
+			sv.validate(items, baseUri, value[i], jsonPointerAppend(path, strconv.Itoa(i)), depth+1)
+		}
+	}
+}
+
+func (sv *schemaValidation) validateObject(schema map[string]any, baseUri string, value map[string]any, path string, depth int) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, found := value[key]; !found {
+					sv.fail(path, "missing required property '%s'", key)
+				}
+			}
+		}
+	}
+	if minProperties, ok := schemaNumber(schema["minProperties"]); ok && float64(len(value)) < minProperties {
+		sv.fail(path, "object has fewer than %s properties", formatMetricValue(minProperties))
+	}
+	if maxProperties, ok := schemaNumber(schema["maxProperties"]); ok && float64(len(value)) > maxProperties {
+		sv.fail(path, "object has more than %s properties", formatMetricValue(maxProperties))
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	additionalProperties, hasAdditionalProperties := schema["additionalProperties"]
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // NOTE: Report violations in a deterministic order.
+
+	for _, key := range keys {
+		propertyPath := jsonPointerAppend(path, key)
+		if propertySchema, found := properties[key]; found {
+			sv.validate(propertySchema, baseUri, value[key], propertyPath, depth+1)
+		} else if hasAdditionalProperties {
+			if allowed, ok := additionalProperties.(bool); ok && !allowed {
+				sv.fail(propertyPath, "additional property is not allowed")
+			} else {
+				sv.validate(additionalProperties, baseUri, value[key], propertyPath, depth+1)
+			}
+		}
+	}
+}
+
+// Registers a JSON Schema document under its URI, so messages can be validated against it, either by referencing
+// it in the Schema of the PubsubOptions or in the dataschema attribute of the cloud-event. The URI can have any
+// form, but relative references between documents are resolved against it.
+func (ev *events) RegisterSchema(uri string, document []byte) error {
+	return ev.schemas.register(uri, document)
+}
+
+// Registers all JSON Schema documents (files ending with .json) in the directory and its subdirectories, under their
+// $id. When the $id of a document ends with its path relative to the directory, the rest of the $id is taken as the
+// base URI of the directory, and documents without $id are registered under the base URI followed by their relative
+// path, so relative references between the documents resolve. Every document can also be referred to by its
+// relative path.
+func (ev *events) LoadSchemas(dir string) error {
+	type schemaFile struct {
+		path     string
+		id       string
+		document []byte
+	}
+
+	fsys := os.DirFS(dir)
+	var files []schemaFile
+	err := fs.WalkDir(fsys, ".", func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(filePath) != ".json" {
+			return nil
+		}
+		document, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			return err
+		}
+		var header struct {
+			Id string `json:"$id"`
+		}
+		json.Unmarshal(document, &header)
+		files = append(files, schemaFile{path: filePath, id: schemaDocumentUri(header.Id), document: document})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	baseUri := ""
+	for _, file := range files {
+		if prefix, found := strings.CutSuffix(file.id, "/"+file.path); found && prefix != "" {
+			baseUri = prefix + "/"
+			break
+		}
+	}
+
+	for _, file := range files {
+		uri := file.path
+		if file.id != "" {
+			uri = file.id
+		} else if baseUri != "" {
+			uri = baseUri + file.path
+		}
+		if err := ev.schemas.register(uri, file.document); err != nil {
+			return err
+		}
+		if uri != file.path {
+			ev.schemas.alias(file.path, uri)
+		}
+	}
+	return nil
+}
+
+// Returns the schema to validate the message data against: the schema of the subscription, or else the dataschema
+// of the cloud-event when it is registered. Unregistered dataschemas are considered informational.
+func (entry pubsubEntry) messageSchema(schemas *schemaRegistry, msg Message) string {
+	if entry.options.Schema != "" {
+		return entry.options.Schema
+	}
+	if msg.Fields.Schema != "" && schemas.has(msg.Fields.Schema) {
+		return msg.Fields.Schema
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package daprsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	defaultSidecarHttpPort = "3500"
+	sidecarApiTokenHeader  = "dapr-api-token"
+)
+
+type SidecarOptions struct {
+	Endpoint   string       // NOTE: Base url of the http api of the dapr daemon, e.g. "http://localhost:3500".
+	ApiToken   string       // NOTE: Sent in the dapr-api-token header when set.
+	HttpClient *http.Client // NOTE: The default http client is used when nil.
+}
+
+// Returns the options for the dapr daemon of the application, from DAPR_HTTP_ENDPOINT, or else DAPR_HTTP_PORT
+// (3500 by default) on localhost, and DAPR_API_TOKEN.
+func SidecarOptionsFromEnv() SidecarOptions {
+	endpoint := os.Getenv("DAPR_HTTP_ENDPOINT")
+	if endpoint == "" {
+		port := os.Getenv("DAPR_HTTP_PORT")
+		if port == "" {
+			port = defaultSidecarHttpPort
+		}
+		endpoint = "http://127.0.0.1:" + port
+	}
+	return SidecarOptions{
+		Endpoint: endpoint,
+		ApiToken: os.Getenv("DAPR_API_TOKEN"),
+	}
+}
+
+// Error response of the dapr daemon.
+type SidecarError struct {
+	StatusCode int
+	ErrorCode  string // NOTE: Like "ERR_PUBSUB_NOT_FOUND", empty when the response has no dapr error body.
+	Message    string
+}
+
+func (err *SidecarError) Error() string {
+	if err.ErrorCode != "" {
+		return fmt.Sprintf("Dapr daemon responded with status %d (%s): %s", err.StatusCode, err.ErrorCode, err.Message)
+	}
+	return fmt.Sprintf("Dapr daemon responded with status %d: %s", err.StatusCode, err.Message)
+}
+
+func sidecarErrorFromResponse(resp *http.Response, body []byte) *SidecarError {
+	daprErr := struct {
+		ErrorCode string `json:"errorCode"`
+		Message   string `json:"message"`
+	}{}
+	if json.Unmarshal(body, &daprErr) == nil && daprErr.ErrorCode != "" {
+		return &SidecarError{StatusCode: resp.StatusCode, ErrorCode: daprErr.ErrorCode, Message: daprErr.Message}
+	}
+	return &SidecarError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
+
+// Client for the http api of the dapr daemon of the application.
+type SidecarClient struct {
+	options SidecarOptions
+}
+
+func NewSidecarClient(options SidecarOptions) *SidecarClient {
+	options.Endpoint = strings.TrimSuffix(options.Endpoint, "/")
+	return &SidecarClient{options: options}
+}
+
+func (sc *SidecarClient) httpClient() *http.Client {
+	if sc.options.HttpClient == nil {
+		return http.DefaultClient
+	}
+	return sc.options.HttpClient
+}
+
+// Sends a request to the dapr daemon and returns the response body of a successful response. The trace context of
+// the incoming message or request, if any, is propagated in a new span, unless the header has a traceparent.
+func (sc *SidecarClient) do(ctx context.Context, method string, path string, query url.Values, header http.Header, body []byte) (*http.Response, []byte, error) {
+	requestUrl := sc.options.Endpoint + path
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, bodyReader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create dapr daemon request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if sc.options.ApiToken != "" {
+		req.Header.Set(sidecarApiTokenHeader, sc.options.ApiToken)
+	}
+	if tc, ok := TraceFromContext(ctx); ok && req.Header.Get("Traceparent") == "" {
+		tc.NewChild().SetHeader(req.Header)
+	}
+
+	resp, err := sc.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to send dapr daemon request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read dapr daemon response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, respBody, sidecarErrorFromResponse(resp, respBody)
+	}
+	return resp, respBody, nil
+}
+
+// Sets the options of the client for the dapr daemon, which are read from the environment by default.
+func (svc *daprSvc) SetSidecarOptions(options SidecarOptions) {
+	svc.sidecar = NewSidecarClient(options)
+}
+
+// Returns the client for the dapr daemon of the application.
+func (svc *daprSvc) Sidecar() *SidecarClient {
+	return svc.sidecar
+}
@@ -0,0 +1,295 @@
+package daprsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type StateConcurrency string
+
+const (
+	StateFirstWrite StateConcurrency = "first-write" // NOTE: Writes fail when the etag doesn't match the stored one.
+	StateLastWrite  StateConcurrency = "last-write"
+)
+
+type StateConsistency string
+
+const (
+	StateEventual StateConsistency = "eventual"
+	StateStrong   StateConsistency = "strong"
+)
+
+type StateOptions struct {
+	Concurrency StateConcurrency // NOTE: Default of the state store when empty, usually last-write.
+	Consistency StateConsistency // NOTE: Default of the state store when empty, usually eventual.
+}
+
+type StateItem struct {
+	Key          string
+	Value        any               // NOTE: Stored as json; a json.RawMessage is stored as is.
+	Etag         string            // NOTE: If set, the item is only written when the stored etag matches.
+	Metadata     map[string]string // NOTE: Metadata for the state store component.
+	TtlInSeconds int               // NOTE: If set, the item expires after this time.
+	Options      StateOptions
+}
+
+// Stored state of a key. The value is nil when the key has no state.
+type StateEntry struct {
+	Key   string
+	Value json.RawMessage
+	Etag  string
+	Error error // NOTE: Set when getting the state of the key failed, in a bulk get.
+}
+
+func (entry StateEntry) Found() bool {
+	return entry.Value != nil
+}
+
+// Decodes the json value of the entry.
+func (entry StateEntry) Json(v any) error {
+	if entry.Error != nil {
+		return entry.Error
+	}
+	if !entry.Found() {
+		return fmt.Errorf("No state for key '%s'.", entry.Key)
+	}
+	return json.Unmarshal(entry.Value, v)
+}
+
+type GetStateOptions struct {
+	Consistency StateConsistency
+	Metadata    map[string]string
+}
+
+type DeleteStateOptions struct {
+	Etag     string // NOTE: If set, the state is only deleted when the stored etag matches.
+	Metadata map[string]string
+	Options  StateOptions
+}
+
+type BulkGetStateOptions struct {
+	Parallelism int // NOTE: Number of concurrent gets by the dapr daemon, when the state store has no bulk get.
+	Metadata    map[string]string
+}
+
+type StateOperationType string
+
+const (
+	StateUpsert StateOperationType = "upsert"
+	StateDelete StateOperationType = "delete"
+)
+
+// Operation of a state transaction. For deletes only the key, etag, metadata and options of the item are used.
+type StateOperation struct {
+	Type StateOperationType
+	Item StateItem
+}
+
+// Reports whether the error is caused by an etag that doesn't match the stored etag.
+func IsEtagMismatch(err error) bool {
+	var sidecarErr *SidecarError
+	return errors.As(err, &sidecarErr) && sidecarErr.StatusCode == http.StatusConflict
+}
+
+func metadataQuery(query url.Values, metadata map[string]string) url.Values {
+	if query == nil {
+		query = make(url.Values)
+	}
+	for key, value := range metadata {
+		query.Set("metadata."+key, value)
+	}
+	return query
+}
+
+func statePath(storeName string, segments ...string) string {
+	path := "/v1.0/state/" + url.PathEscape(storeName)
+	for _, segment := range segments {
+		path += "/" + url.PathEscape(segment)
+	}
+	return path
+}
+
+type stateItemRequest struct {
+	Key      string            `json:"key"`
+	Value    json.RawMessage   `json:"value,omitempty"`
+	Etag     string            `json:"etag,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Options  *stateOptionsJson `json:"options,omitempty"`
+}
+
+type stateOptionsJson struct {
+	Concurrency StateConcurrency `json:"concurrency,omitempty"`
+	Consistency StateConsistency `json:"consistency,omitempty"`
+}
+
+func (item StateItem) request(withValue bool) (stateItemRequest, error) {
+	req := stateItemRequest{Key: item.Key, Etag: item.Etag}
+	if withValue {
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return req, fmt.Errorf("Failed to encode state value for key '%s': %w", item.Key, err)
+		}
+		req.Value = value
+	}
+
+	if len(item.Metadata) > 0 || item.TtlInSeconds > 0 {
+		req.Metadata = make(map[string]string, len(item.Metadata)+1)
+		for key, value := range item.Metadata {
+			req.Metadata[key] = value
+		}
+		if item.TtlInSeconds > 0 {
+			req.Metadata["ttlInSeconds"] = strconv.Itoa(item.TtlInSeconds)
+		}
+	}
+
+	if item.Options != (StateOptions{}) {
+		req.Options = &stateOptionsJson{Concurrency: item.Options.Concurrency, Consistency: item.Options.Consistency}
+	}
+	return req, nil
+}
+
+// Returns the state of the key; the entry has no value when the key has no state.
+func (sc *SidecarClient) GetState(ctx context.Context, storeName string, key string, options GetStateOptions) (StateEntry, error) {
+	query := metadataQuery(nil, options.Metadata)
+	if options.Consistency != "" {
+		query.Set("consistency", string(options.Consistency))
+	}
+
+	resp, body, err := sc.do(ctx, http.MethodGet, statePath(storeName, key), query, nil, nil)
+	if err != nil {
+		return StateEntry{}, fmt.Errorf("Failed to get state '%s' from store '%s': %w", key, storeName, err)
+	}
+
+	entry := StateEntry{Key: key, Etag: resp.Header.Get("ETag")}
+	if resp.StatusCode != http.StatusNoContent && len(body) > 0 {
+		entry.Value = body
+	}
+	return entry, nil
+}
+
+// Saves the items in one request. With an etag and first-write concurrency, saving fails when the etag doesn't match
+// the stored etag; IsEtagMismatch reports such errors.
+func (sc *SidecarClient) SaveState(ctx context.Context, storeName string, items ...StateItem) error {
+	requests := make([]stateItemRequest, 0, len(items))
+	for _, item := range items {
+		req, err := item.request(true)
+		if err != nil {
+			return fmt.Errorf("Failed to save state to store '%s': %w", storeName, err)
+		}
+		requests = append(requests, req)
+	}
+
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return fmt.Errorf("Failed to encode state for store '%s': %w", storeName, err)
+	}
+	if _, _, err := sc.do(ctx, http.MethodPost, statePath(storeName), nil, http.Header{"Content-Type": {"application/json"}}, body); err != nil {
+		return fmt.Errorf("Failed to save state to store '%s': %w", storeName, err)
+	}
+	return nil
+}
+
+func (sc *SidecarClient) DeleteState(ctx context.Context, storeName string, key string, options DeleteStateOptions) error {
+	query := metadataQuery(nil, options.Metadata)
+	if options.Options.Concurrency != "" {
+		query.Set("concurrency", string(options.Options.Concurrency))
+	}
+	if options.Options.Consistency != "" {
+		query.Set("consistency", string(options.Options.Consistency))
+	}
+	header := http.Header{}
+	if options.Etag != "" {
+		header.Set("If-Match", options.Etag)
+	}
+
+	if _, _, err := sc.do(ctx, http.MethodDelete, statePath(storeName, key), query, header, nil); err != nil {
+		return fmt.Errorf("Failed to delete state '%s' from store '%s': %w", key, storeName, err)
+	}
+	return nil
+}
+
+// Returns the state of the keys, in the order of the keys. Keys without state have entries without value, and keys
+// for which the state store returned an error have entries with that error.
+func (sc *SidecarClient) GetBulkState(ctx context.Context, storeName string, keys []string, options BulkGetStateOptions) ([]StateEntry, error) {
+	request := struct {
+		Keys        []string `json:"keys"`
+		Parallelism int      `json:"parallelism,omitempty"`
+	}{Keys: keys, Parallelism: options.Parallelism}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode bulk state request for store '%s': %w", storeName, err)
+	}
+
+	_, respBody, err := sc.do(ctx, http.MethodPost, statePath(storeName, "bulk"), metadataQuery(nil, options.Metadata), http.Header{"Content-Type": {"application/json"}}, body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get bulk state from store '%s': %w", storeName, err)
+	}
+
+	items := []struct {
+		Key   string          `json:"key"`
+		Data  json.RawMessage `json:"data"`
+		Etag  string          `json:"etag"`
+		Error string          `json:"error"`
+	}{}
+	if err := json.Unmarshal(respBody, &items); err != nil {
+		return nil, fmt.Errorf("Failed to decode bulk state response of store '%s': %w", storeName, err)
+	}
+
+	entriesByKey := make(map[string]StateEntry, len(items))
+	for _, item := range items {
+		entry := StateEntry{Key: item.Key, Etag: item.Etag}
+		if item.Error != "" {
+			entry.Error = fmt.Errorf("Failed to get state '%s' from store '%s': %s", item.Key, storeName, item.Error)
+		} else if len(item.Data) > 0 && string(item.Data) != "null" {
+			entry.Value = item.Data
+		}
+		entriesByKey[item.Key] = entry
+	}
+
+	entries := make([]StateEntry, 0, len(keys))
+	for _, key := range keys {
+		entry, found := entriesByKey[key]
+		if !found {
+			entry = StateEntry{Key: key}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Executes the operations atomically, on state stores that support transactions.
+func (sc *SidecarClient) ExecuteStateTransaction(ctx context.Context, storeName string, operations []StateOperation, metadata map[string]string) error {
+	type operationRequest struct {
+		Operation StateOperationType `json:"operation"`
+		Request   stateItemRequest   `json:"request"`
+	}
+
+	requests := make([]operationRequest, 0, len(operations))
+	for _, op := range operations {
+		if op.Type != StateUpsert && op.Type != StateDelete {
+			return fmt.Errorf("Invalid state operation '%s' for key '%s'.", op.Type, op.Item.Key)
+		}
+		req, err := op.Item.request(op.Type == StateUpsert)
+		if err != nil {
+			return fmt.Errorf("Failed to execute state transaction on store '%s': %w", storeName, err)
+		}
+		requests = append(requests, operationRequest{Operation: op.Type, Request: req})
+	}
+
+	body, err := json.Marshal(struct {
+		Operations []operationRequest `json:"operations"`
+		Metadata   map[string]string  `json:"metadata,omitempty"`
+	}{Operations: requests, Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("Failed to encode state transaction for store '%s': %w", storeName, err)
+	}
+	if _, _, err := sc.do(ctx, http.MethodPost, statePath(storeName, "transaction"), nil, http.Header{"Content-Type": {"application/json"}}, body); err != nil {
+		return fmt.Errorf("Failed to execute state transaction on store '%s': %w", storeName, err)
+	}
+	return nil
+}
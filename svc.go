@@ -1,10 +1,112 @@
 package daprsvc
 
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
 type daprSvc struct {
 	invocation
 	events
+	logger       *slog.Logger
+	metrics      *metricsRegistry
+	metricsRoute string
+	// NOTE: Set when the metrics route is not a valid route, reported by validation.
+	metricsRouteErr error
+
+	maxMessageBodySize int64
+
+	basePath           string
+	messageRoutePrefix string
+	fallbackHandler    http.Handler
+
+	sidecar *SidecarClient
 }
 
 func New() *daprSvc {
-	return &daprSvc{}
+	return &daprSvc{
+		metrics:            newMetricsRegistry(),
+		messageRoutePrefix: "/message",
+		sidecar:            NewSidecarClient(SidecarOptionsFromEnv()),
+	}
+}
+
+// Normalizes a route path to start with a slash and not end with one. The root path becomes empty, so paths can
+// be concatenated.
+func normalizeRoutePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// Sets the path under which all Dapr endpoints (/dapr/subscribe, message routes and metrics) are served. Note that
+// the Dapr daemon requests /dapr/subscribe from the root path of the application, so with a base path that request
+// needs to be forwarded by the application.
+func (svc *daprSvc) SetBasePath(basePath string) {
+	svc.basePath = normalizeRoutePath(basePath)
+}
+
+// Sets the path prefix of the routes to which the Dapr daemon delivers pubsub messages, "/message" by default.
+func (svc *daprSvc) SetMessageRoutePrefix(prefix string) {
+	svc.messageRoutePrefix = normalizeRoutePath(prefix)
+}
+
+// Sets the handler for requests that are neither invocation requests nor requests to a Dapr endpoint, so the
+// application can serve its own endpoints next to the Dapr service.
+func (svc *daprSvc) SetFallbackHandler(handler http.Handler) {
+	svc.fallbackHandler = handler
+}
+
+// Sets the logger for structured records about incoming messages and invocation requests. The default logger of
+// the slog package is used when no logger is set.
+func (svc *daprSvc) SetLogger(logger *slog.Logger) {
+	svc.logger = logger
+}
+
+func (svc *daprSvc) getLogger() *slog.Logger {
+	if svc.logger == nil {
+		return slog.Default()
+	}
+	return svc.logger
+}
+
+// Returns a handler serving the metrics of the service in the Prometheus text exposition format.
+func (svc *daprSvc) MetricsHandler() http.Handler {
+	return svc.metrics
+}
+
+// Sets the route where the http handler of the service serves the metrics, normalized like the message route
+// prefix. Metrics are not served by the http handler of the service when no route is set. A route without path
+// segments, like "/", is invalid.
+func (svc *daprSvc) SetMetricsRoute(route string) {
+	svc.metricsRoute = normalizeRoutePath(route)
+	svc.metricsRouteErr = nil
+	if route != "" && svc.metricsRoute == "" {
+		svc.metricsRouteErr = fmt.Errorf("Metrics route '%s' is empty after normalization.", route)
+	}
+}
+
+// Reports all registration problems of the service together, as one error. Returns nil when there are none.
+func (svc *daprSvc) Validate() error {
+	return errors.Join(append(svc.events.validate(), svc.metricsRouteErr)...)
+}
+
+func (svc *daprSvc) messageHandlerRoutePrefix() string {
+	return svc.basePath + svc.messageRoutePrefix
+}
+
+// Returns the subscriptions of the service to pubsub topics, in registration order.
+func (svc *daprSvc) Subscriptions() []Subscription {
+	return svc.subscriptions(svc.messageHandlerRoutePrefix())
+}
+
+// Sets the maximum size in bytes of message request bodies, for all subscriptions without their own maximum.
+// Larger messages are dropped. There is no maximum when the size is zero.
+func (svc *daprSvc) SetMaxMessageBodySize(size int64) {
+	svc.maxMessageBodySize = size
 }
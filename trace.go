@@ -0,0 +1,186 @@
+package daprsvc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	traceFlagSampled = 0x01
+	maxTraceStateLen = 32
+)
+
+type TraceStateEntry struct {
+	Key   string
+	Value string
+}
+
+// W3C trace context, as passed in the traceparent and tracestate headers.
+type TraceContext struct {
+	TraceId  string // NOTE: 32 lowercase hex characters.
+	ParentId string // NOTE: 16 lowercase hex characters, identifying the span of the caller.
+	Flags    byte
+	State    []TraceStateEntry
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isAllZeros(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+// Parses a traceparent header value and an optional tracestate header value. Invalid tracestate list members are
+// left out.
+func ParseTraceContext(traceparent string, tracestate string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, fmt.Errorf("Invalid traceparent '%s'.", traceparent)
+	}
+
+	version := parts[0]
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return TraceContext{}, fmt.Errorf("Invalid traceparent version in '%s'.", traceparent)
+	}
+	if !isLowerHex(parts[1], 32) || isAllZeros(parts[1]) {
+		return TraceContext{}, fmt.Errorf("Invalid trace-id in traceparent '%s'.", traceparent)
+	}
+	if !isLowerHex(parts[2], 16) || isAllZeros(parts[2]) {
+		return TraceContext{}, fmt.Errorf("Invalid parent-id in traceparent '%s'.", traceparent)
+	}
+	if !isLowerHex(parts[3], 2) {
+		return TraceContext{}, fmt.Errorf("Invalid trace-flags in traceparent '%s'.", traceparent)
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+
+	return TraceContext{
+		TraceId:  parts[1],
+		ParentId: parts[2],
+		Flags:    byte(flags),
+		State:    parseTraceState(tracestate),
+	}, nil
+}
+
+func parseTraceState(tracestate string) (result []TraceStateEntry) {
+	for _, member := range strings.Split(tracestate, ",") {
+		member = strings.TrimSpace(member)
+		key, value, found := strings.Cut(member, "=")
+		if !found || key == "" || value == "" {
+			continue
+		}
+		result = append(result, TraceStateEntry{Key: key, Value: value})
+		if len(result) == maxTraceStateLen {
+			break
+		}
+	}
+	return
+}
+
+// Extracts the trace context from the traceparent and tracestate headers.
+func TraceContextFromHeader(header http.Header) (TraceContext, error) {
+	traceparent := header.Get("Traceparent")
+	if traceparent == "" {
+		return TraceContext{}, errors.New("No traceparent header present.")
+	}
+	return ParseTraceContext(traceparent, strings.Join(header.Values("Tracestate"), ","))
+}
+
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&traceFlagSampled != 0
+}
+
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceId, tc.ParentId, tc.Flags)
+}
+
+func (tc TraceContext) Tracestate() string {
+	members := make([]string, 0, len(tc.State))
+	for _, entry := range tc.State {
+		members = append(members, entry.Key+"="+entry.Value)
+	}
+	return strings.Join(members, ",")
+}
+
+// Sets the traceparent and tracestate headers for propagating the trace context to a downstream call.
+func (tc TraceContext) SetHeader(header http.Header) {
+	header.Set("Traceparent", tc.Traceparent())
+	if tracestate := tc.Tracestate(); tracestate != "" {
+		header.Set("Tracestate", tracestate)
+	} else {
+		header.Del("Tracestate")
+	}
+}
+
+// Returns the trace context for a new span within the same trace, to pass to a downstream call.
+func (tc TraceContext) NewChild() TraceContext {
+	child := tc
+	child.ParentId = NewSpanId()
+	child.State = append([]TraceStateEntry(nil), tc.State...)
+	return child
+}
+
+func randomHex(numBytes int) string {
+	for {
+		buf := make([]byte, numBytes)
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Errorf("Failed to generate random id: %w", err))
+		}
+		if id := hex.EncodeToString(buf); !isAllZeros(id) {
+			return id
+		}
+	}
+}
+
+// Generates a random span id, usable as parent-id in a traceparent.
+func NewSpanId() string {
+	return randomHex(8)
+}
+
+// Starts a new sampled trace, for calls that don't originate from an incoming request or message.
+func NewTraceContext() TraceContext {
+	return TraceContext{
+		TraceId:  randomHex(16),
+		ParentId: NewSpanId(),
+		Flags:    traceFlagSampled,
+	}
+}
+
+type traceContextKey struct{}
+
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// Returns the trace context of the incoming message or invocation request that the context belongs to.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// Attaches the trace context of the message to the context, falling back to the trace headers of the request.
+func contextWithMessageTrace(ctx context.Context, msg Message, header http.Header) context.Context {
+	if msg.Trace.Parent != "" {
+		if tc, err := ParseTraceContext(msg.Trace.Parent, msg.Trace.State); err == nil {
+			return ContextWithTrace(ctx, tc)
+		}
+	}
+	if tc, err := TraceContextFromHeader(header); err == nil {
+		return ContextWithTrace(ctx, tc)
+	}
+	return ctx
+}